@@ -0,0 +1,95 @@
+package dts
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// FileDiff contain content changes of a single file
+type FileDiff struct {
+	File   string  `json:"file"`
+	Binary bool    `json:"binary,omitempty"`
+	Hunks  []*Hunk `json:"hunks,omitempty"`
+}
+
+// Hunk contain added and removed lines of a single diff hunk
+type Hunk struct {
+	Header   string   `json:"header"`
+	OldStart int      `json:"old_start"`
+	OldLines int      `json:"old_lines"`
+	NewStart int      `json:"new_start"`
+	NewLines int      `json:"new_lines"`
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+}
+
+// ParseDiff parse unified diff produced by git diff into the list of file diffs
+func ParseDiff(b []byte) (diffs []*FileDiff) {
+	var fd *FileDiff
+	var hunk *Hunk
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			fd = &FileDiff{File: parseDiffHeader(strings.TrimPrefix(line, "diff --git "))}
+			hunk = nil
+			diffs = append(diffs, fd)
+		case fd == nil:
+			continue
+		case hunk == nil && strings.HasPrefix(line, "+++ "):
+			// "+++ /dev/null" means that file was deleted, name from the header is kept
+			// git appends a tab to names containing spaces
+			if name := strings.TrimSuffix(strings.TrimPrefix(line, "+++ "), "\t"); name != "/dev/null" {
+				fd.File = strings.TrimPrefix(name, "b/")
+			}
+		case hunk == nil && strings.HasPrefix(line, "Binary files "):
+			fd.Binary = true
+		case strings.HasPrefix(line, "@@ "):
+			hunk = parseHunkHeader(line)
+			fd.Hunks = append(fd.Hunks, hunk)
+		case hunk == nil:
+			continue
+		case strings.HasPrefix(line, "+"):
+			hunk.Added = append(hunk.Added, line[1:])
+		case strings.HasPrefix(line, "-"):
+			hunk.Removed = append(hunk.Removed, line[1:])
+		}
+	}
+
+	return
+}
+
+// parseDiffHeader extract file name from "a/<name> b/<name>" part of the diff header
+func parseDiffHeader(s string) string {
+	// both names are equal unless file was renamed, so the header is split in half
+	if n := (len(s) - 1) / 2; len(s)%2 == 1 && s[n] == ' ' && strings.HasPrefix(s, "a/") {
+		return s[n+3:]
+	}
+
+	return s
+}
+
+// parseHunkHeader parse "@@ -l,s +l,s @@" line, omitted line count means 1
+func parseHunkHeader(line string) *Hunk {
+	hunk := &Hunk{Header: line, OldLines: 1, NewLines: 1}
+	m := hunkHeader.FindStringSubmatch(line)
+	if m == nil {
+		return hunk
+	}
+
+	hunk.OldStart, _ = strconv.Atoi(m[1])
+	if len(m[2]) > 0 {
+		hunk.OldLines, _ = strconv.Atoi(m[2])
+	}
+
+	hunk.NewStart, _ = strconv.Atoi(m[3])
+	if len(m[4]) > 0 {
+		hunk.NewLines, _ = strconv.Atoi(m[4])
+	}
+
+	return hunk
+}
@@ -0,0 +1,93 @@
+package dts
+
+import "testing"
+
+const testDiff = `diff --git a/conf/app.conf b/conf/app.conf
+index 3b18e51..a9a1b4c 100644
+--- a/conf/app.conf
++++ b/conf/app.conf
+@@ -1,3 +1,3 @@
+ host=localhost
+-port=8080
++port=9090
+ user=app
+@@ -10 +10,2 @@ [pool]
+ size=1
++timeout=5
+diff --git a/lib/app.so b/lib/app.so
+index 1c2d3e4..5f6a7b8 100644
+Binary files a/lib/app.so and b/lib/app.so differ
+diff --git a/old.conf b/old.conf
+deleted file mode 100644
+index 3b18e51..0000000
+--- a/old.conf
++++ /dev/null
+@@ -1 +0,0 @@
+-removed
+diff --git a/my app.conf b/my app.conf
+index 3b18e51..a9a1b4c 100644
+--- a/my app.conf
++++ b/my app.conf
+@@ -1 +1 @@
+-a
++b
+`
+
+func TestParseDiff(t *testing.T) {
+	diffs := ParseDiff([]byte(testDiff))
+	if len(diffs) != 4 {
+		t.Fatalf("ParseDiff() returned %d files, want 4", len(diffs))
+	}
+
+	conf := diffs[0]
+	if conf.File != "conf/app.conf" || conf.Binary || len(conf.Hunks) != 2 {
+		t.Fatalf("diff of conf/app.conf = %+v", conf)
+	}
+
+	h := conf.Hunks[0]
+	if h.OldStart != 1 || h.OldLines != 3 || h.NewStart != 1 || h.NewLines != 3 {
+		t.Errorf("hunk header = %+v, want -1,3 +1,3", h)
+	}
+
+	if len(h.Added) != 1 || h.Added[0] != "port=9090" || len(h.Removed) != 1 || h.Removed[0] != "port=8080" {
+		t.Errorf("hunk lines: added %q, removed %q", h.Added, h.Removed)
+	}
+
+	// omitted line count means a single line
+	h = conf.Hunks[1]
+	if h.OldStart != 10 || h.OldLines != 1 || h.NewStart != 10 || h.NewLines != 2 || len(h.Added) != 1 || len(h.Removed) != 0 {
+		t.Errorf("hunk = %+v, want -10 +10,2 with a single added line", h)
+	}
+
+	if bin := diffs[1]; bin.File != "lib/app.so" || !bin.Binary || len(bin.Hunks) != 0 {
+		t.Errorf("diff of lib/app.so = %+v, want binary without hunks", bin)
+	}
+
+	if del := diffs[2]; del.File != "old.conf" || len(del.Hunks) != 1 || del.Hunks[0].NewLines != 0 || len(del.Hunks[0].Removed) != 1 {
+		t.Errorf("diff of deleted old.conf = %+v", del)
+	}
+
+	if sp := diffs[3]; sp.File != "my app.conf" {
+		t.Errorf("name of the file with a space = %q, want %q", sp.File, "my app.conf")
+	}
+}
+
+func TestParseDiffEmpty(t *testing.T) {
+	if diffs := ParseDiff(nil); len(diffs) != 0 {
+		t.Errorf("ParseDiff(nil) = %+v, want none", diffs)
+	}
+}
+
+func TestParseDiffHeader(t *testing.T) {
+	tests := map[string]string{
+		"a/app.conf b/app.conf":       "app.conf",
+		"a/my app.conf b/my app.conf": "my app.conf",
+		"a/old.conf b/new.conf":       "new.conf",
+	}
+
+	for header, want := range tests {
+		if got := parseDiffHeader(header); got != want {
+			t.Errorf("parseDiffHeader(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -2,119 +2,324 @@ package dts
 
 import (
 	"fmt"
-	"os/exec"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 )
 
+const (
+	userName  = "Go-DTS"
+	userEmail = "bss-devautotools@megafon.ru"
+)
+
 //type MFiles map[string]int
 
 type MFiles struct {
-	Changes  map[string]int `json:"changes,omitempty"`
-	Binaries []string       `json:"binaries,omitempty"`
+	Changes  map[string]*Change     `json:"changes,omitempty"`
+	Binaries []string               `json:"binaries,omitempty"`
+	Added    []string               `json:"added,omitempty"`
+	Deleted  []string               `json:"deleted,omitempty"`
+	Renamed  map[string]string      `json:"renamed,omitempty"`
+	Metadata map[string]*MetaChange `json:"metadata,omitempty"`
+	// BaselineTime is the time of the baseline the work tree was compared with
+	BaselineTime string `json:"baseline_time,omitempty"`
+}
+
+// Change contain number of inserted and deleted lines of a modified text file
+type Change struct {
+	Insertions int `json:"insertions"`
+	Deletions  int `json:"deletions"`
+}
+
+// Commit describe why and by whom a baseline was created
+type Commit struct {
+	Trigger string `json:"trigger"`
+	Author  string `json:"author,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
+// Baseline contain description of a single baseline commit
+type Baseline struct {
+	Hash    string `json:"hash"`
+	Time    string `json:"time"`
+	Trigger string `json:"trigger,omitempty"`
+	Author  string `json:"author"`
+	Message string `json:"message,omitempty"`
+	Files   int    `json:"files"`
+}
+
+// repository is a small set of git operations go-dts needs to keep baselines of a work tree in the external git dir
+type repository interface {
+	// add write given files of the work tree into the object storage and replace the index with them,
+	// restore writes back the previous index
+	add(files []string) (restore func() error, err error)
+	// commit staged files, returns hash of the new commit
+	commit(c *Commit) (string, error)
+	// numstat count changed lines of tracked files against the index and compare files with tracked ones
+	// to find added, deleted and renamed files
+	numstat(files []string) (*MFiles, error)
+	// diff return unified diff of tracked files against the index
+	diff() ([]byte, error)
+	// history return list of commits starting from HEAD
+	history() ([]*Baseline, error)
+	// drifted resolve baseline to the commit hash and return its files which differ from the work tree
+	drifted(baseline string) (string, []string, error)
+	// blob return content of the file stored in the given commit
+	blob(hash, file string) ([]byte, error)
+	// files return names of the files stored in the given commit
+	files(hash string) ([]string, error)
+	// head return hash and time of the HEAD commit
+	head() (string, time.Time, error)
+}
+
+// openRepository open repository with the external git dir, create == true initialize it first
+var openRepository = openGoGit
+
 func Init(workTree, gitDir string) (output []byte, err error) {
-	var b []byte
-	// init repository
-	args := []string{"git", "--work-tree", workTree, "--git-dir", gitDir, "init"}
-	b, err = execCmd(args)
+	if _, err = openRepository(workTree, gitDir, true); err != nil {
+		return
+	}
+
+	output = []byte(fmt.Sprintf("Initialized git repository in %s\n", gitDir))
+	return
+}
+
+// AddNCommit commit given files as a new baseline replacing files of the previous one, large files are not committed,
+// only their hashes are kept in the baseline manifest, unreadable files are kept there by metadata
+func AddNCommit(workTree, gitDir string, files, large, unreadable []string, c *Commit) (output []byte, err error) {
+	r, err := openRepository(workTree, gitDir, false)
 	if err != nil {
 		return
 	}
-	output = append(output, b...)
 
-	// write repository config name
-	args = append(args[:len(args)-1], "config", "user.name", "\"Go-DTS\"")
-	b, err = execCmd(args)
+	restore, err := r.add(files)
 	if err != nil {
 		return
 	}
-	output = append(output, b...)
 
-	// write repository config email
-	args = append(args[:len(args)-2], "user.email", "\"bss-devautotools@megafon.ru\"")
-	b, err = execCmd(args)
+	hash, err := r.commit(c)
 	if err != nil {
+		// index is compared with the work tree by status, so it must keep matching HEAD
+		if e := restore(); e != nil {
+			err = fmt.Errorf("%s, previous index is not restored: %s", err, e)
+		}
 		return
 	}
-	output = append(output, b...)
 
+	m, err := buildManifest(workTree, files, large, unreadable)
+	if err != nil {
+		return
+	}
+
+	if err = m.write(gitDir, hash); err != nil {
+		return
+	}
+
+	output = []byte(fmt.Sprintf("[%.8s] %s: %d files added, %d files hashed\n", hash, c.Trigger, len(files), len(large)))
 	return
 }
 
-func AddNCommit(workTree, gitDir string, files []string) (output []byte, err error) {
-	var b []byte
-	args := []string{"git", "--work-tree", workTree, "--git-dir", gitDir, "add"}
-	for i := 0; i < len(files); i++ {
-		if b, err = execCmd(append(args, files[i])); err != nil {
-			return
-		}
+// Numstat return changes of the work tree against the baseline, files are accessible files of the work tree,
+// those of them which are not tracked are reported as added, mode and owner changes are taken from baseline manifest.
+// Large files are compared with the manifest by size and hash
+func Numstat(workTree, gitDir string, files, large []string) (mFiles *MFiles, err error) {
+	r, err := openRepository(workTree, gitDir, false)
+	if err != nil {
+		return
+	}
+
+	if mFiles, err = r.numstat(files); err != nil {
+		return
+	}
+
+	hash, when, err := r.head()
+	if err != nil {
+		return
+	}
+	mFiles.BaselineTime = when.Format(time.RFC3339)
 
-		output = append(output, b...)
+	m, err := readManifest(gitDir, hash)
+	if err != nil || m == nil {
+		return
 	}
 
-	t := time.Now().Format(time.RFC3339)
-	args = append(args[:len(args)-1], "commit", "-m", t)
-	if b, err = execCmd(args); err != nil {
+	if err = m.compareLarge(workTree, large, mFiles); err != nil {
 		return
 	}
 
-	output = append(output, b...)
+	mFiles.Metadata, err = m.compare(workTree)
 	return
 }
 
-func Numstat(workTree, gitDir string) (mFiles *MFiles, err error) {
-	args := []string{"git", "--work-tree", workTree, "--git-dir", gitDir, "diff", "--numstat"}
-	b, err := execCmd(args)
+// Diff return unified diff of the work tree against the baseline
+func Diff(workTree, gitDir string) ([]byte, error) {
+	r, err := openRepository(workTree, gitDir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.diff()
+}
+
+// History return list of baselines starting from the latest one
+func History(workTree, gitDir string) ([]*Baseline, error) {
+	r, err := openRepository(workTree, gitDir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.history()
+}
+
+// Drifted resolve given baseline (HEAD if empty) to the commit hash and return tracked files which differ from it
+func Drifted(workTree, gitDir, baseline string) (hash string, files []string, err error) {
+	r, err := openRepository(workTree, gitDir, false)
 	if err != nil {
 		return
 	}
 
-	mFiles = &MFiles{
-		Changes:  make(map[string]int),
-		Binaries: make([]string, 0),
+	if len(baseline) == 0 {
+		baseline = "HEAD"
+	}
+
+	return r.drifted(baseline)
+}
+
+// Blob return content of the file stored in the given baseline
+func Blob(workTree, gitDir, hash, file string) ([]byte, error) {
+	r, err := openRepository(workTree, gitDir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.blob(hash, file)
+}
+
+// BaselineFiles return sorted names of the files of the given baseline: committed ones and those kept only
+// by the baseline manifest, e.g. large and unreadable files
+func BaselineFiles(workTree, gitDir, hash string) ([]string, error) {
+	r, err := openRepository(workTree, gitDir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := r.files(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := readManifest(gitDir, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := make(map[string]bool, len(files))
+	for i := 0; i < len(files); i++ {
+		committed[files[i]] = true
 	}
 
-	r := strings.NewReplacer("\\t", "\t", "\\n", "\n", "\\r\\n", "\r\n")
-	output := r.Replace(string(b))
-	lines := strings.Split(output, "\n")
-	for i := 0; i < len(lines); i++ {
-		parts := strings.Split(lines[i], "\t")
-		if len(parts) == 3 {
-			deletions, err := strconv.Atoi(parts[0])
-			if err != nil {
-				mFiles.Binaries = append(mFiles.Binaries, parts[2])
-				continue
-			}
+	for name := range m {
+		if !committed[name] {
+			files = append(files, name)
+		}
+	}
 
-			insertions, err := strconv.Atoi(parts[1])
-			if err != nil {
-				mFiles.Binaries = append(mFiles.Binaries, parts[2])
-				continue
-			}
+	sort.Strings(files)
+	return files, nil
+}
 
-			mFiles.Changes[parts[2]] = deletions + insertions
+// parseMessage split commit message made by Commit.message on trigger and description, timestamp subject is skipped
+func parseMessage(msg string) (trigger, description string) {
+	lines := strings.Split(strings.TrimSpace(msg), "\n")
+	body := make([]string, 0, len(lines))
+	for i := 1; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "Trigger: ") {
+			trigger = strings.TrimPrefix(lines[i], "Trigger: ")
+			continue
 		}
+
+		body = append(body, lines[i])
 	}
 
+	description = strings.TrimSpace(strings.Join(body, "\n"))
 	return
 }
 
-func execCmd(args []string) ([]byte, error) {
-	var command string
-	if len(args) > 0 {
-		command = args[0]
-		args = args[1:]
+// message return commit message: timestamp as a subject, optional description and trigger trailer
+func (c *Commit) message(t time.Time) string {
+	msg := t.Format(time.RFC3339)
+	if len(c.Message) > 0 {
+		msg += "\n\n" + c.Message
 	}
-	return exec.Command(command, args...).Output()
+
+	return msg + "\n\nTrigger: " + c.Trigger
 }
 
-func (mf *MFiles) Telegraf(appName string) (s []string) {
-	for k, v := range mf.Changes {
-		s = append(s, fmt.Sprintf("data-tracking-system,appl_name=%s,filename=%s count=%d", appName, k, v))
+// Telegraf return changes as lines of the influx line protocol, one point per file with the change type.
+// Given tags are added to every point
+func (mf *MFiles) Telegraf(tags map[string]string) (s []string) {
+	for _, k := range sortedKeys(mf.Changes) {
+		p := mf.point(tags, k, "modified")
+		p.Fields["insertions"] = mf.Changes[k].Insertions
+		p.Fields["deletions"] = mf.Changes[k].Deletions
+		// count is kept for existing dashboards
+		p.Fields["count"] = float64(mf.Changes[k].Lines())
+		s = append(s, p.String())
+	}
+
+	for i := 0; i < len(mf.Binaries); i++ {
+		p := mf.point(tags, mf.Binaries[i], "modified")
+		p.Fields["binary"] = true
+		s = append(s, p.String())
+	}
+
+	for i := 0; i < len(mf.Added); i++ {
+		s = append(s, mf.point(tags, mf.Added[i], "added").String())
+	}
+
+	for i := 0; i < len(mf.Deleted); i++ {
+		s = append(s, mf.point(tags, mf.Deleted[i], "deleted").String())
+	}
+
+	renamed := make([]string, 0, len(mf.Renamed))
+	for k := range mf.Renamed {
+		renamed = append(renamed, k)
+	}
+	sort.Strings(renamed)
+	for _, k := range renamed {
+		p := mf.point(tags, mf.Renamed[k], "renamed")
+		p.Fields["renamed_from"] = k
+		s = append(s, p.String())
+	}
+
+	metadata := make([]string, 0, len(mf.Metadata))
+	for k := range mf.Metadata {
+		metadata = append(metadata, k)
+	}
+	sort.Strings(metadata)
+	for _, k := range metadata {
+		v := mf.Metadata[k]
+		p := mf.point(tags, k, "metadata")
+		p.Fields["mode"] = v.New.Mode
+		p.Fields["uid"] = v.New.Uid
+		p.Fields["gid"] = v.New.Gid
+		s = append(s, p.String())
 	}
 
 	return
 }
+
+// point return point of the file with the given change type
+func (mf *MFiles) point(tags map[string]string, filename, changeType string) *Point {
+	p := NewPoint(tags)
+	p.Tags["filename"] = filename
+	p.Fields["change_type"] = changeType
+	p.Fields["binary"] = false
+
+	return p
+}
+
+// Lines return total number of changed lines
+func (c *Change) Lines() int {
+	return c.Insertions + c.Deletions
+}
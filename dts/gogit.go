@@ -0,0 +1,622 @@
+package dts
+
+import (
+	"bytes"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// goGit is a repository backed by the pure go git implementation, on-disk layout is the same as the one
+// created by "git --work-tree <work-tree> --git-dir <git-dir> init", so existing git dirs keep working
+type goGit struct {
+	repo     *git.Repository
+	workTree billy.Filesystem
+}
+
+func openGoGit(workTree, gitDir string, create bool) (repository, error) {
+	s := filesystem.NewStorage(osfs.New(gitDir), cache.NewObjectLRUDefault())
+	if create {
+		if err := initStorage(s, workTree); err != nil {
+			return nil, err
+		}
+	}
+
+	wt := osfs.New(workTree)
+	r, err := git.Open(s, wt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &goGit{repo: r, workTree: wt}, nil
+}
+
+// initStorage create git dir layout, HEAD and config. Unlike git.Init it doesn't write .git file into the work tree,
+// and as git init it keeps existing repository untouched
+func initStorage(s *filesystem.Storage, workTree string) error {
+	if err := s.Init(); err != nil {
+		return err
+	}
+
+	_, err := s.Reference(plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound {
+		err = s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	cfg, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.Core.IsBare = false
+	cfg.Core.Worktree = workTree
+	cfg.User.Name = userName
+	cfg.User.Email = userEmail
+	return s.SetConfig(cfg)
+}
+
+// add stage all files with a single index write, git.Worktree.Add computes status of the whole work tree on every call
+func (g *goGit) add(files []string) (restore func() error, err error) {
+	prev, err := g.repo.Storer.Index()
+	if err != nil {
+		return
+	}
+
+	// new index is built in memory and written once every blob is stored, so a failure keeps the previous one
+	idx := &index.Index{Version: prev.Version}
+	for i := 0; i < len(files); i++ {
+		name := filepath.ToSlash(files[i])
+		fi, err := g.workTree.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := g.read(name, fi)
+		if err != nil {
+			return nil, err
+		}
+
+		e := idx.Add(name)
+		if e.Hash, err = g.writeBlob(content); err != nil {
+			return nil, err
+		}
+
+		if e.Mode, err = filemode.NewFromOSFileMode(fi.Mode()); err != nil {
+			return nil, err
+		}
+
+		e.ModifiedAt = fi.ModTime()
+		e.Size = uint32(fi.Size())
+	}
+
+	if err = g.repo.Storer.SetIndex(idx); err != nil {
+		return
+	}
+
+	return func() error { return g.repo.Storer.SetIndex(prev) }, nil
+}
+
+func (g *goGit) commit(c *Commit) (string, error) {
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	opts := &git.CommitOptions{AllowEmptyCommits: true}
+	if len(c.Author) > 0 {
+		cfg, err := g.repo.Config()
+		if err != nil {
+			return "", err
+		}
+
+		opts.Author = parseSignature(c.Author)
+		opts.Committer = &object.Signature{Name: cfg.User.Name, Email: cfg.User.Email, When: opts.Author.When}
+	}
+
+	h, err := wt.Commit(c.message(time.Now()), opts)
+	if err != nil {
+		return "", err
+	}
+
+	return h.String(), nil
+}
+
+func (g *goGit) numstat(files []string) (*MFiles, error) {
+	mFiles := &MFiles{
+		Changes:  make(map[string]*Change),
+		Binaries: make([]string, 0),
+		Renamed:  make(map[string]string),
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	// deleted files grouped by content hash to detect renames
+	deleted := make(map[plumbing.Hash][]string)
+	err = g.forEachModified(idx, func(e *index.Entry, from, to []byte) {
+		if to == nil {
+			deleted[e.Hash] = append(deleted[e.Hash], e.Name)
+			return
+		}
+
+		if isBinary(from) || isBinary(to) {
+			mFiles.Binaries = append(mFiles.Binaries, e.Name)
+			return
+		}
+
+		insertions, deletions := lineStat(from, to)
+		mFiles.Changes[e.Name] = &Change{Insertions: insertions, Deletions: deletions}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool, len(idx.Entries))
+	for _, e := range idx.Entries {
+		tracked[e.Name] = true
+	}
+
+	for i := 0; i < len(files); i++ {
+		name := filepath.ToSlash(files[i])
+		if tracked[name] {
+			continue
+		}
+
+		fi, err := g.workTree.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := g.read(name, fi)
+		if err != nil {
+			return nil, err
+		}
+
+		// new file with the same content as the deleted one is considered as renamed
+		h := plumbing.ComputeHash(plumbing.BlobObject, content)
+		if names := deleted[h]; len(names) > 0 {
+			mFiles.Renamed[names[0]] = name
+			deleted[h] = names[1:]
+			continue
+		}
+
+		mFiles.Added = append(mFiles.Added, name)
+	}
+
+	for _, names := range deleted {
+		mFiles.Deleted = append(mFiles.Deleted, names...)
+	}
+
+	sort.Strings(mFiles.Added)
+	sort.Strings(mFiles.Deleted)
+	return mFiles, nil
+}
+
+func (g *goGit) diff() ([]byte, error) {
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	var p patch
+	err = g.forEachModified(idx, func(e *index.Entry, from, to []byte) {
+		fp := &filePatch{
+			from:   &patchFile{hash: e.Hash, mode: e.Mode, path: e.Name},
+			binary: isBinary(from) || isBinary(to),
+		}
+
+		// nil content means that the file was deleted
+		if to != nil {
+			fp.to = &patchFile{hash: plumbing.ComputeHash(plumbing.BlobObject, to), mode: e.Mode, path: e.Name}
+		}
+
+		if !fp.binary {
+			fp.chunks = chunks(from, to)
+		}
+
+		p = append(p, fp)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	err = fdiff.NewUnifiedEncoder(buf, fdiff.DefaultContextLines).Encode(p)
+	return buf.Bytes(), err
+}
+
+func (g *goGit) history() (baselines []*Baseline, err error) {
+	iter, err := g.repo.Log(&git.LogOptions{})
+	if err == plumbing.ErrReferenceNotFound {
+		// there are no commits yet
+		return nil, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	err = iter.ForEach(func(c *object.Commit) error {
+		baseline := &Baseline{
+			Hash:   c.Hash.String(),
+			Time:   c.Author.When.Format(time.RFC3339),
+			Author: c.Author.Name,
+		}
+
+		baseline.Trigger, baseline.Message = parseMessage(c.Message)
+		var err error
+		if baseline.Files, err = changedFiles(c); err != nil {
+			return err
+		}
+
+		baselines = append(baselines, baseline)
+		return nil
+	})
+
+	return
+}
+
+func (g *goGit) drifted(baseline string) (hash string, files []string, err error) {
+	h, err := g.repo.ResolveRevision(plumbing.Revision(baseline))
+	if err != nil {
+		return
+	}
+
+	c, err := g.repo.CommitObject(*h)
+	if err != nil {
+		return
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		fi, err := g.workTree.Lstat(f.Name)
+		if os.IsNotExist(err) || err == nil && fi.IsDir() {
+			files = append(files, f.Name)
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		content, err := g.read(f.Name, fi)
+		if err != nil {
+			return err
+		}
+
+		if plumbing.ComputeHash(plumbing.BlobObject, content) != f.Hash {
+			files = append(files, f.Name)
+		}
+
+		return nil
+	})
+
+	return h.String(), files, err
+}
+
+func (g *goGit) blob(hash, file string) ([]byte, error) {
+	c, err := g.repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.File(filepath.ToSlash(file))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := f.Contents()
+	return []byte(s), err
+}
+
+func (g *goGit) files(hash string) (names []string, err error) {
+	c, err := g.repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		names = append(names, f.Name)
+		return nil
+	})
+
+	return
+}
+
+func (g *goGit) head() (string, time.Time, error) {
+	ref, err := g.repo.Head()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	c, err := g.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return c.Hash.String(), c.Author.When, nil
+}
+
+// forEachModified call fn for every index entry which content differs from the work tree,
+// from is the staged content, to is the content of the work tree or nil if the file was deleted
+func (g *goGit) forEachModified(idx *index.Index, fn func(e *index.Entry, from, to []byte)) error {
+	for _, e := range idx.Entries {
+		to, ok, err := g.modified(e)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		from, err := g.readBlob(e.Hash)
+		if err != nil {
+			return err
+		}
+
+		fn(e, from, to)
+	}
+
+	return nil
+}
+
+// modified return current content of the file tracked by the index entry, ok is false when the file is not modified,
+// nil content means that the file was deleted
+func (g *goGit) modified(e *index.Entry) (content []byte, ok bool, err error) {
+	fi, err := g.workTree.Lstat(e.Name)
+	if os.IsNotExist(err) || err == nil && fi.IsDir() {
+		return nil, true, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	// the same stat data as in the index means that the file wasn't touched since it was staged
+	if fi.ModTime().Equal(e.ModifiedAt) && uint32(fi.Size()) == e.Size {
+		return nil, false, nil
+	}
+
+	if content, err = g.read(e.Name, fi); err != nil {
+		return
+	}
+
+	if content == nil {
+		content = []byte{}
+	}
+
+	return content, plumbing.ComputeHash(plumbing.BlobObject, content) != e.Hash, nil
+}
+
+// read return content of the work tree file, for symlinks it is the link target as git stores it
+func (g *goGit) read(name string, fi os.FileInfo) ([]byte, error) {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := g.workTree.Readlink(name)
+		return []byte(target), err
+	}
+
+	f, err := g.workTree.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func (g *goGit) readBlob(h plumbing.Hash) ([]byte, error) {
+	b, err := g.repo.BlobObject(h)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (g *goGit) writeBlob(content []byte) (h plumbing.Hash, err error) {
+	obj := g.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	w, err := obj.Writer()
+	if err != nil {
+		return
+	}
+
+	if _, err = w.Write(content); err != nil {
+		return
+	}
+
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	return g.repo.Storer.SetEncodedObject(obj)
+}
+
+// changedFiles return number of files touched by the commit
+func changedFiles(c *object.Commit) (n int, err error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return
+	}
+
+	if c.NumParents() == 0 {
+		err = tree.Files().ForEach(func(*object.File) error {
+			n++
+			return nil
+		})
+		return
+	}
+
+	parent, err := c.Parent(0)
+	if err != nil {
+		return
+	}
+
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	return len(changes), err
+}
+
+// parseSignature parse "Name <email>" string, email is optional
+func parseSignature(s string) *object.Signature {
+	sig := &object.Signature{Name: strings.TrimSpace(s), When: time.Now()}
+	if i := strings.Index(s, "<"); i >= 0 {
+		sig.Name = strings.TrimSpace(s[:i])
+		sig.Email = strings.Trim(strings.TrimSpace(s[i+1:]), ">")
+	}
+
+	return sig
+}
+
+func isBinary(b []byte) bool {
+	ok, _ := binary.IsBinary(bytes.NewReader(b))
+	return ok
+}
+
+// lineStat count inserted and deleted lines between two versions of a file
+func lineStat(from, to []byte) (insertions, deletions int) {
+	for _, d := range diff.Do(string(from), string(to)) {
+		n := strings.Count(d.Text, "\n")
+		if len(d.Text) > 0 && !strings.HasSuffix(d.Text, "\n") {
+			n++
+		}
+
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			insertions += n
+		case diffmatchpatch.DiffDelete:
+			deletions += n
+		}
+	}
+
+	return
+}
+
+func chunks(from, to []byte) (c []fdiff.Chunk) {
+	for _, d := range diff.Do(string(from), string(to)) {
+		op := fdiff.Equal
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = fdiff.Add
+		case diffmatchpatch.DiffDelete:
+			op = fdiff.Delete
+		}
+
+		c = append(c, &chunk{content: d.Text, op: op})
+	}
+
+	return
+}
+
+// patch implements diff.Patch interface to encode work tree changes by the unified encoder
+type patch []fdiff.FilePatch
+
+func (p patch) FilePatches() []fdiff.FilePatch {
+	return p
+}
+
+func (p patch) Message() string {
+	return ""
+}
+
+type filePatch struct {
+	from, to *patchFile
+	chunks   []fdiff.Chunk
+	binary   bool
+}
+
+func (fp *filePatch) IsBinary() bool {
+	return fp.binary
+}
+
+// Files return untyped nil for absent file, otherwise the encoder can't tell new or deleted file
+func (fp *filePatch) Files() (from, to fdiff.File) {
+	if fp.from != nil {
+		from = fp.from
+	}
+
+	if fp.to != nil {
+		to = fp.to
+	}
+
+	return
+}
+
+func (fp *filePatch) Chunks() []fdiff.Chunk {
+	return fp.chunks
+}
+
+type patchFile struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+	path string
+}
+
+func (f *patchFile) Hash() plumbing.Hash {
+	return f.hash
+}
+
+func (f *patchFile) Mode() filemode.FileMode {
+	return f.mode
+}
+
+func (f *patchFile) Path() string {
+	return f.path
+}
+
+type chunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c *chunk) Content() string {
+	return c.content
+}
+
+func (c *chunk) Type() fdiff.Operation {
+	return c.op
+}
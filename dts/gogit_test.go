@@ -0,0 +1,253 @@
+package dts
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeFiles write files of the work tree, missing dirs are created
+func writeFiles(t *testing.T, workTree string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(workTree, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// baseline create git dir of the work tree and commit given files
+func baseline(t *testing.T, files map[string]string) (workTree, gitDir string) {
+	t.Helper()
+	workTree, gitDir = t.TempDir(), filepath.Join(t.TempDir(), "42")
+	writeFiles(t, workTree, files)
+
+	if _, err := Init(workTree, gitDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AddNCommit(workTree, gitDir, sortedNames(files), nil, nil, &Commit{Trigger: "init", Author: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestGoGitNumstat(t *testing.T) {
+	workTree, gitDir := baseline(t, map[string]string{
+		"conf/app.conf": "host=localhost\nport=8080\nuser=app\n",
+		"old.conf":      "moved\n",
+		"gone.conf":     "deleted\n",
+		"lib/app.so":    "\x00\x01\x02",
+		"same.conf":     "same\n",
+	})
+
+	writeFiles(t, workTree, map[string]string{
+		"conf/app.conf": "host=localhost\nport=9090\nuser=app\ntimeout=5\n",
+		"new.conf":      "moved\n",
+		"added.conf":    "added\n",
+		"lib/app.so":    "\x00\x03",
+	})
+
+	for _, name := range []string{"old.conf", "gone.conf"} {
+		if err := os.Remove(filepath.Join(workTree, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []string{"added.conf", "conf/app.conf", "lib/app.so", "new.conf", "same.conf"}
+	mf, err := Numstat(workTree, gitDir, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := mf.Changes["conf/app.conf"]; len(mf.Changes) != 1 || c == nil || c.Insertions != 2 || c.Deletions != 1 {
+		t.Errorf("changes = %+v, want conf/app.conf with 2 insertions and 1 deletion", mf.Changes)
+	}
+
+	if len(mf.Binaries) != 1 || mf.Binaries[0] != "lib/app.so" {
+		t.Errorf("binaries = %q, want lib/app.so", mf.Binaries)
+	}
+
+	if len(mf.Added) != 1 || mf.Added[0] != "added.conf" {
+		t.Errorf("added = %q, want added.conf", mf.Added)
+	}
+
+	if len(mf.Deleted) != 1 || mf.Deleted[0] != "gone.conf" {
+		t.Errorf("deleted = %q, want gone.conf", mf.Deleted)
+	}
+
+	if len(mf.Renamed) != 1 || mf.Renamed["old.conf"] != "new.conf" {
+		t.Errorf("renamed = %v, want old.conf renamed to new.conf", mf.Renamed)
+	}
+
+	if len(mf.BaselineTime) == 0 {
+		t.Error("baseline time is not set")
+	}
+
+	b, err := Diff(workTree, gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diffs []string
+	for _, fd := range ParseDiff(b) {
+		diffs = append(diffs, fd.File)
+	}
+	sort.Strings(diffs)
+	if strings.Join(diffs, ",") != "conf/app.conf,gone.conf,lib/app.so,old.conf" {
+		t.Errorf("diff of %q, want conf/app.conf, gone.conf, lib/app.so and old.conf", diffs)
+	}
+}
+
+func TestGoGitBaselines(t *testing.T) {
+	workTree, gitDir := baseline(t, map[string]string{"a.conf": "a\n", "b.conf": "b\n"})
+	first, err := History(workTree, gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, workTree, map[string]string{"a.conf": "changed\n"})
+	if _, err = AddNCommit(workTree, gitDir, []string{"a.conf", "b.conf"}, nil, nil, &Commit{Trigger: "accept", Author: "test", Message: "new a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := History(workTree, gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 2 || history[0].Trigger != "accept" || history[0].Message != "new a" || history[0].Files != 1 || history[1].Trigger != "init" || history[1].Files != 2 {
+		t.Fatalf("history = %+v, want accept and init baselines", history)
+	}
+
+	hash, drifted, err := Drifted(workTree, gitDir, first[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash != first[0].Hash || len(drifted) != 1 || drifted[0] != "a.conf" {
+		t.Errorf("Drifted() = %s, %q, want a.conf drifted from %s", hash, drifted, first[0].Hash)
+	}
+
+	if b, err := Blob(workTree, gitDir, hash, "a.conf"); err != nil || string(b) != "a\n" {
+		t.Errorf("Blob() = %q, %v, want content of the first baseline", b, err)
+	}
+
+	if files, err := BaselineFiles(workTree, gitDir, hash); err != nil || strings.Join(files, ",") != "a.conf,b.conf" {
+		t.Errorf("BaselineFiles() = %q, %v", files, err)
+	}
+}
+
+// TestGoGitCompatibility check that git dirs are interchangeable with the ones of the git binary
+func TestGoGitCompatibility(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	workTree, gitDir := baseline(t, map[string]string{"a.conf": "a\n", "dir/b.conf": "b\n"})
+	out, err := exec.Command("git", "--git-dir", gitDir, "--work-tree", workTree, "ls-tree", "-r", "--name-only", "HEAD").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git ls-tree: %s: %s", err, out)
+	}
+
+	if got := strings.Fields(string(out)); strings.Join(got, ",") != "a.conf,dir/b.conf" {
+		t.Errorf("git ls-tree = %q, want a.conf and dir/b.conf", got)
+	}
+
+	// git dir created by the git binary is read by go-git
+	workTree, gitDir = t.TempDir(), filepath.Join(t.TempDir(), "43")
+	writeFiles(t, workTree, map[string]string{"a.conf": "a\n"})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "a.conf"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "baseline"},
+	} {
+		cmd := exec.Command("git", append([]string{"--git-dir", gitDir, "--work-tree", workTree}, args...)...)
+		cmd.Dir = workTree
+		if out, err = cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s: %s", args[0], err, out)
+		}
+	}
+
+	writeFiles(t, workTree, map[string]string{"a.conf": "a\nb\n"})
+	mf, err := Numstat(workTree, gitDir, []string{"a.conf"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := mf.Changes["a.conf"]; c == nil || c.Insertions != 1 || c.Deletions != 0 {
+		t.Errorf("changes = %+v, want a.conf with a single insertion", mf.Changes)
+	}
+}
+
+func TestBaselineFilesKeptByManifest(t *testing.T) {
+	workTree, gitDir := t.TempDir(), filepath.Join(t.TempDir(), "42")
+	writeFiles(t, workTree, map[string]string{"a.conf": "a\n", "big.bin": "large", "secret.conf": "secret\n"})
+	if _, err := Init(workTree, gitDir); err != nil {
+		t.Fatal(err)
+	}
+
+	commit := &Commit{Trigger: "init", Author: "test"}
+	if _, err := AddNCommit(workTree, gitDir, []string{"a.conf"}, []string{"big.bin"}, []string{"secret.conf"}, commit); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := History(workTree, gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// large and unreadable files are not committed, but they are part of the baseline
+	files, err := BaselineFiles(workTree, gitDir, history[0].Hash)
+	if err != nil || strings.Join(files, ",") != "a.conf,big.bin,secret.conf" {
+		t.Errorf("BaselineFiles() = %q, %v, want a.conf, big.bin and secret.conf", files, err)
+	}
+}
+
+func TestGoGitAddKeepIndexOnError(t *testing.T) {
+	workTree, gitDir := baseline(t, map[string]string{"a.conf": "a\n", "b.conf": "b\n"})
+
+	// file disappeared between the walk and the commit
+	commit := &Commit{Trigger: "accept", Author: "test"}
+	if _, err := AddNCommit(workTree, gitDir, []string{"a.conf", "b.conf", "gone.conf"}, nil, nil, commit); err == nil {
+		t.Fatal("AddNCommit() of a missing file succeeded")
+	}
+
+	mf, err := Numstat(workTree, gitDir, []string{"a.conf", "b.conf"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mf.Added) != 0 || len(mf.Deleted) != 0 || len(mf.Changes) != 0 {
+		t.Errorf("status after failed accept = %+v, want no changes", mf)
+	}
+
+	// files missing from the new baseline are dropped
+	if _, err = AddNCommit(workTree, gitDir, []string{"a.conf"}, nil, nil, commit); err != nil {
+		t.Fatal(err)
+	}
+
+	if mf, err = Numstat(workTree, gitDir, []string{"a.conf", "b.conf"}, nil); err != nil || len(mf.Added) != 1 || mf.Added[0] != "b.conf" {
+		t.Errorf("Numstat() = %+v, %v, want b.conf added", mf, err)
+	}
+}
@@ -0,0 +1,99 @@
+package dts
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const measurement = "data-tracking-system"
+
+var (
+	// measurementEscaper escape measurement name according to the influx line protocol
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	// keyEscaper escape tag keys, tag values and field keys according to the influx line protocol
+	keyEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	// stringEscaper escape string field values according to the influx line protocol
+	stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Point is a single point of the influx line protocol, field values can be int, int64, float64, bool or string.
+// Zero time means that the point is timestamped by the receiver
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// NewPoint return point of the default measurement with a copy of given tags
+func NewPoint(tags map[string]string) *Point {
+	p := &Point{Measurement: measurement, Tags: make(map[string]string, len(tags)+1), Fields: make(map[string]interface{})}
+	for k, v := range tags {
+		p.Tags[k] = v
+	}
+
+	return p
+}
+
+// String encode point into a line of the influx line protocol, tags and fields are sorted by key,
+// tags with empty values are omitted since line protocol doesn't allow them
+func (p *Point) String() string {
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+
+	tags := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+
+	for _, k := range tags {
+		if len(p.Tags[k]) == 0 {
+			continue
+		}
+
+		b.WriteString("," + keyEscaper.Replace(k) + "=" + keyEscaper.Replace(p.Tags[k]))
+	}
+
+	fields := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	for i, k := range fields {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(",")
+		}
+
+		b.WriteString(keyEscaper.Replace(k) + "=" + fieldValue(p.Fields[k]))
+	}
+
+	if !p.Time.IsZero() {
+		b.WriteString(" " + strconv.FormatInt(p.Time.UnixNano(), 10))
+	}
+
+	return b.String()
+}
+
+func fieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v) + "i"
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`
+	default:
+		return `"` + stringEscaper.Replace(fmt.Sprint(v)) + `"`
+	}
+}
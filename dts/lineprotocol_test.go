@@ -0,0 +1,99 @@
+package dts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPointString(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *Point
+		want string
+	}{
+		{
+			name: "sorted tags and fields",
+			p: &Point{
+				Measurement: measurement,
+				Tags:        map[string]string{"instance": "42", "appl_name": "app"},
+				Fields:      map[string]interface{}{"insertions": 3, "count": float64(5), "binary": false},
+			},
+			want: `data-tracking-system,appl_name=app,instance=42 binary=false,count=5,insertions=3i`,
+		},
+		{
+			name: "escaped tags",
+			p: &Point{
+				Measurement: measurement,
+				Tags:        map[string]string{"filename": `conf dir/a,b=c.conf`},
+				Fields:      map[string]interface{}{"change_type": "added"},
+			},
+			want: `data-tracking-system,filename=conf\ dir/a\,b\=c.conf change_type="added"`,
+		},
+		{
+			name: "trailing backslash",
+			p: &Point{
+				Measurement: measurement,
+				Tags:        map[string]string{"filename": `C:\app\`, "host": "h1"},
+				Fields:      map[string]interface{}{"deletions": int64(1)},
+			},
+			want: `data-tracking-system,filename=C:\\app\\,host=h1 deletions=1i`,
+		},
+		{
+			name: "escaped string field and empty tag",
+			p: &Point{
+				Measurement: measurement,
+				Tags:        map[string]string{"stand": "", "host": "h1"},
+				Fields:      map[string]interface{}{"renamed_from": `say "hi"\n`},
+			},
+			want: `data-tracking-system,host=h1 renamed_from="say \"hi\"\\n"`,
+		},
+		{
+			name: "timestamp",
+			p: &Point{
+				Measurement: "data-tracking-system-event",
+				Fields:      map[string]interface{}{"dir": true},
+				Time:        time.Unix(1, 5),
+			},
+			want: `data-tracking-system-event dir=true 1000000005`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.String(); got != tt.want {
+				t.Errorf("String() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMFilesTelegrafIsSorted(t *testing.T) {
+	mf := &MFiles{
+		Changes: map[string]*Change{"b.conf": {Insertions: 1}, "a.conf": {Deletions: 2}},
+		Renamed: map[string]string{"old2": "new2", "old1": "new1"},
+		Metadata: map[string]*MetaChange{
+			"z.sh": {Old: &FileMeta{Mode: "-rw-r--r--"}, New: &FileMeta{Mode: "-rwxr-xr-x"}},
+			"y.sh": {Old: &FileMeta{Mode: "-rw-r--r--"}, New: &FileMeta{Mode: "----------"}},
+		},
+	}
+
+	want := []string{
+		`data-tracking-system,filename=a.conf,host=h1 binary=false,change_type="modified",count=2,deletions=2i,insertions=0i`,
+		`data-tracking-system,filename=b.conf,host=h1 binary=false,change_type="modified",count=1,deletions=0i,insertions=1i`,
+		`data-tracking-system,filename=new1,host=h1 binary=false,change_type="renamed",renamed_from="old1"`,
+		`data-tracking-system,filename=new2,host=h1 binary=false,change_type="renamed",renamed_from="old2"`,
+		`data-tracking-system,filename=y.sh,host=h1 binary=false,change_type="metadata",gid=0i,mode="----------",uid=0i`,
+		`data-tracking-system,filename=z.sh,host=h1 binary=false,change_type="metadata",gid=0i,mode="-rwxr-xr-x",uid=0i`,
+	}
+
+	got := mf.Telegraf(map[string]string{"host": "h1"})
+	if len(got) != len(want) {
+		t.Fatalf("Telegraf() = %q, want %q", got, want)
+	}
+
+	for i := 0; i < len(want); i++ {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
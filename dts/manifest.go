@@ -0,0 +1,211 @@
+package dts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const manifestsDir = "manifests"
+
+// FileMeta contain file metadata which git doesn't keep, files too large for git are tracked by the content hash
+type FileMeta struct {
+	Mode   string `json:"mode"`
+	Uid    int    `json:"uid"`
+	Gid    int    `json:"gid"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// MetaChange contain metadata of a file at the moment of baseline and the current one
+type MetaChange struct {
+	Old *FileMeta `json:"old"`
+	New *FileMeta `json:"new"`
+}
+
+// Manifest map slash separated file names to their metadata, manifest is stored in the git dir next to each baseline
+type Manifest map[string]*FileMeta
+
+func newFileMeta(fi os.FileInfo) *FileMeta {
+	uid, gid := owner(fi)
+	return &FileMeta{Mode: fi.Mode().String(), Uid: uid, Gid: gid, Size: fi.Size()}
+}
+
+// buildManifest collect metadata of the given work tree files, large files are tracked only by the manifest
+// so they are additionally hashed. Unreadable files are kept by metadata only, so they are still part of the baseline
+func buildManifest(workTree string, files, large, unreadable []string) (Manifest, error) {
+	m := make(Manifest, len(files)+len(large)+len(unreadable))
+	for _, names := range [][]string{files, unreadable} {
+		for i := 0; i < len(names); i++ {
+			fi, err := os.Lstat(filepath.Join(workTree, names[i]))
+			if err != nil {
+				return nil, err
+			}
+
+			m[filepath.ToSlash(names[i])] = newFileMeta(fi)
+		}
+	}
+
+	for i := 0; i < len(large); i++ {
+		path := filepath.Join(workTree, large[i])
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		meta := newFileMeta(fi)
+		if meta.Sha256, err = sha256File(path); err != nil {
+			return nil, err
+		}
+
+		m[filepath.ToSlash(large[i])] = meta
+	}
+
+	return m, nil
+}
+
+// readManifest read manifest of the baseline, baselines created before manifests were introduced have no one
+func readManifest(gitDir, hash string) (Manifest, error) {
+	b, err := ioutil.ReadFile(manifestPath(gitDir, hash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := Manifest{}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+func (m Manifest) write(gitDir, hash string) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	path := manifestPath(gitDir, hash)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// compare return mode, owner and file type changes of the manifest files, files are checked whether or not they
+// are readable now, since losing read permission is a change to catch. Deleted files are reported by numstat
+func (m Manifest) compare(workTree string) (map[string]*MetaChange, error) {
+	changes := make(map[string]*MetaChange)
+	for name, old := range m {
+		fi, err := os.Lstat(filepath.Join(workTree, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// mode string starts with the file type, so replacing a file with a symlink or a directory changes it too
+		meta := newFileMeta(fi)
+		if meta.Mode != old.Mode || meta.Uid != old.Uid || meta.Gid != old.Gid {
+			changes[name] = &MetaChange{Old: old, New: meta}
+		}
+	}
+
+	return changes, nil
+}
+
+// compareLarge check hash tracked files of the manifest against the work tree, changed size or hash is reported
+// as a binary drift. Large files which are not in the manifest are reported as added
+func (m Manifest) compareLarge(workTree string, large []string, mFiles *MFiles) error {
+	current := make(map[string]bool, len(large))
+	for i := 0; i < len(large); i++ {
+		name := filepath.ToSlash(large[i])
+		current[name] = true
+		if _, ok := m[name]; !ok {
+			mFiles.Added = append(mFiles.Added, name)
+		}
+	}
+
+	for name, meta := range m {
+		if len(meta.Sha256) == 0 {
+			continue
+		}
+
+		path := filepath.Join(workTree, filepath.FromSlash(name))
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			mFiles.Deleted = append(mFiles.Deleted, name)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// file became small enough to be accessible, it isn't tracked by git so numstat reports it as added
+		if !current[name] {
+			mFiles.Added = removeString(mFiles.Added, name)
+		}
+
+		if fi.Size() != meta.Size {
+			mFiles.Binaries = append(mFiles.Binaries, name)
+			continue
+		}
+
+		// file which lost read permission is reported by the metadata comparison
+		sum, err := sha256File(path)
+		if os.IsPermission(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if sum != meta.Sha256 {
+			mFiles.Binaries = append(mFiles.Binaries, name)
+		}
+	}
+
+	sort.Strings(mFiles.Added)
+	sort.Strings(mFiles.Deleted)
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func removeString(s []string, v string) []string {
+	for i := 0; i < len(s); i++ {
+		if s[i] == v {
+			return append(s[:i], s[i+1:]...)
+		}
+	}
+
+	return s
+}
+
+func manifestPath(gitDir, hash string) string {
+	return filepath.Join(gitDir, manifestsDir, hash+".json")
+}
@@ -0,0 +1,139 @@
+package dts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestCompare(t *testing.T) {
+	workTree := t.TempDir()
+	writeFiles(t, workTree, map[string]string{
+		"conf/secret.conf": "password=1\n",
+		"link.conf":        "file\n",
+		"gone.conf":        "gone\n",
+		"same.conf":        "same\n",
+	})
+
+	m, err := buildManifest(workTree, []string{filepath.Join("conf", "secret.conf"), "link.conf", "gone.conf", "same.conf"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// file which can't be read anymore must be reported as well
+	if err = os.Chmod(filepath.Join(workTree, "conf", "secret.conf"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Remove(filepath.Join(workTree, "link.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Symlink("same.conf", filepath.Join(workTree, "link.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Remove(filepath.Join(workTree, "gone.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := m.compare(workTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("compare() = %v, want secret.conf and link.conf", changes)
+	}
+
+	if c := changes["conf/secret.conf"]; c == nil || c.Old.Mode == c.New.Mode || c.New.Mode != "----------" {
+		t.Errorf("change of conf/secret.conf = %+v, want mode dropped to ----------", c)
+	}
+
+	if c := changes["link.conf"]; c == nil || c.New.Mode[0] != 'L' {
+		t.Errorf("change of link.conf = %+v, want symlink", c)
+	}
+}
+
+func TestManifestWriteRead(t *testing.T) {
+	workTree, gitDir := t.TempDir(), t.TempDir()
+	writeFiles(t, workTree, map[string]string{"a.conf": "a\n", "large.bin": "large"})
+
+	m, err := buildManifest(workTree, []string{"a.conf"}, []string{"large.bin"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m["a.conf"].Sha256) != 0 || len(m["large.bin"].Sha256) == 0 {
+		t.Fatalf("manifest = %+v, want only large.bin hashed", m)
+	}
+
+	if err = m.write(gitDir, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readManifest(gitDir, "hash")
+	if err != nil || len(got) != 2 || *got["large.bin"] != *m["large.bin"] {
+		t.Errorf("readManifest() = %+v, %v, want %+v", got, err, m)
+	}
+
+	if got, err = readManifest(gitDir, "missing"); got != nil || err != nil {
+		t.Errorf("readManifest() of baseline without manifest = %+v, %v", got, err)
+	}
+}
+
+func TestManifestCompareLarge(t *testing.T) {
+	workTree := t.TempDir()
+	writeFiles(t, workTree, map[string]string{"a.bin": "a", "b.bin": "b", "c.bin": "c"})
+
+	m, err := buildManifest(workTree, nil, []string{"a.bin", "b.bin", "c.bin"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same size, different content
+	writeFiles(t, workTree, map[string]string{"a.bin": "x", "d.bin": "d"})
+	if err = os.Remove(filepath.Join(workTree, "c.bin")); err != nil {
+		t.Fatal(err)
+	}
+
+	mf := &MFiles{}
+	if err = m.compareLarge(workTree, []string{"a.bin", "b.bin", "d.bin"}, mf); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mf.Binaries) != 1 || mf.Binaries[0] != "a.bin" {
+		t.Errorf("binaries = %q, want a.bin", mf.Binaries)
+	}
+
+	if len(mf.Added) != 1 || mf.Added[0] != "d.bin" || len(mf.Deleted) != 1 || mf.Deleted[0] != "c.bin" {
+		t.Errorf("added = %q, deleted = %q, want d.bin added and c.bin deleted", mf.Added, mf.Deleted)
+	}
+}
+
+func TestManifestCompareLargeUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads files regardless of permissions")
+	}
+
+	workTree := t.TempDir()
+	writeFiles(t, workTree, map[string]string{"big.bin": "large"})
+	m, err := buildManifest(workTree, nil, []string{"big.bin"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Chmod(filepath.Join(workTree, "big.bin"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	mf := &MFiles{}
+	if err = m.compareLarge(workTree, nil, mf); err != nil {
+		t.Fatalf("compareLarge() of unreadable file = %v", err)
+	}
+
+	changes, err := m.compare(workTree)
+	if err != nil || changes["big.bin"] == nil {
+		t.Errorf("compare() = %v, %v, want mode change of big.bin", changes, err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package dts
+
+import (
+	"os"
+	"syscall"
+)
+
+// owner return uid and gid of the file
+func owner(fi os.FileInfo) (uid, gid int) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+
+	return -1, -1
+}
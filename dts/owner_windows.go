@@ -0,0 +1,8 @@
+package dts
+
+import "os"
+
+// owner is not supported on windows, files are reported as owned by nobody
+func owner(fi os.FileInfo) (uid, gid int) {
+	return -1, -1
+}
@@ -0,0 +1,128 @@
+package dts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	promChanges = "data_tracking_system_changes"
+	promFiles   = "data_tracking_system_files"
+)
+
+// labelEscaper escape label values according to the prometheus text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Prometheus return changes in the prometheus text exposition format: number of changed lines per file and
+// a marker of each added, deleted, renamed, binary or metadata changed file
+func (mf *MFiles) Prometheus(appName, instance string) (s []string) {
+	s = append(s,
+		"# HELP "+promChanges+" Number of changed lines of the file against the baseline.",
+		"# TYPE "+promChanges+" gauge",
+	)
+
+	for _, k := range sortedKeys(mf.Changes) {
+		s = append(s, promSample(promChanges, appName, instance, k, "", mf.Changes[k].Lines()))
+	}
+
+	s = append(s,
+		"# HELP "+promFiles+" Files differing from the baseline by change type.",
+		"# TYPE "+promFiles+" gauge",
+	)
+
+	for i := 0; i < len(mf.Binaries); i++ {
+		s = append(s, promSample(promFiles, appName, instance, mf.Binaries[i], "binary", 1))
+	}
+
+	for i := 0; i < len(mf.Added); i++ {
+		s = append(s, promSample(promFiles, appName, instance, mf.Added[i], "added", 1))
+	}
+
+	for i := 0; i < len(mf.Deleted); i++ {
+		s = append(s, promSample(promFiles, appName, instance, mf.Deleted[i], "deleted", 1))
+	}
+
+	renamed := make([]string, 0, len(mf.Renamed))
+	for _, v := range mf.Renamed {
+		renamed = append(renamed, v)
+	}
+	sort.Strings(renamed)
+	for i := 0; i < len(renamed); i++ {
+		s = append(s, promSample(promFiles, appName, instance, renamed[i], "renamed", 1))
+	}
+
+	metadata := make([]string, 0, len(mf.Metadata))
+	for k := range mf.Metadata {
+		metadata = append(metadata, k)
+	}
+	sort.Strings(metadata)
+	for i := 0; i < len(metadata); i++ {
+		s = append(s, promSample(promFiles, appName, instance, metadata[i], "metadata", 1))
+	}
+
+	return
+}
+
+// promSample format a single sample, change type label is omitted if empty
+func promSample(name, appName, instance, filename, changeType string, value int) string {
+	labels := fmt.Sprintf(`appl_name="%s",instance="%s",filename="%s"`,
+		labelEscaper.Replace(appName), labelEscaper.Replace(instance), labelEscaper.Replace(filename))
+	if len(changeType) > 0 {
+		labels += fmt.Sprintf(`,change_type="%s"`, changeType)
+	}
+
+	return fmt.Sprintf("%s{%s} %d", name, labels, value)
+}
+
+func sortedKeys(m map[string]*Change) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// MergePrometheus merge exposition of several instances, so every metric family is described once
+// and its samples are kept together
+func MergePrometheus(groups ...[]string) (s []string) {
+	var names []string
+	families := make(map[string][]string)
+	described := make(map[string]bool)
+	for _, lines := range groups {
+		for _, line := range lines {
+			if len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var name string
+			if strings.HasPrefix(line, "# ") {
+				// description of the family is added once
+				if described[line] {
+					continue
+				}
+				described[line] = true
+
+				if f := strings.Fields(line); len(f) > 2 {
+					name = f[2]
+				}
+			} else {
+				name = strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+			}
+
+			if _, ok := families[name]; !ok {
+				names = append(names, name)
+			}
+
+			families[name] = append(families[name], line)
+		}
+	}
+
+	for _, name := range names {
+		s = append(s, families[name]...)
+	}
+
+	return
+}
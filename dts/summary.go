@@ -0,0 +1,108 @@
+package dts
+
+import (
+	"fmt"
+	"time"
+)
+
+const summaryMeasurement = "data-tracking-system-summary"
+
+// Summary contain totals of the instance changes, so drift of the whole application can be watched by a single series.
+// UnReadable, GtSize and Symlinks are counts of work tree files in these classes, BaselineAge is in seconds.
+// Cached is set if the registry was unreachable and settings of the instance were read from the cache of CacheAge seconds
+type Summary struct {
+	ChangedFiles    int   `json:"changed_files"`
+	LinesChanged    int   `json:"lines_changed"`
+	Binaries        int   `json:"binaries"`
+	NewFiles        int   `json:"new_files"`
+	DeletedFiles    int   `json:"deleted_files"`
+	RenamedFiles    int   `json:"renamed_files"`
+	MetadataChanges int   `json:"metadata_changes"`
+	UnReadable      int   `json:"unreadable"`
+	GtSize          int   `json:"gt_size"`
+	Symlinks        int   `json:"symlinks"`
+	BaselineAge     int64 `json:"baseline_age"`
+	Cached          bool  `json:"cached,omitempty"`
+	CacheAge        int64 `json:"cache_age,omitempty"`
+}
+
+// Summary return totals of the changes, baseline age is counted up to now
+func (mf *MFiles) Summary(now time.Time) *Summary {
+	s := &Summary{
+		ChangedFiles:    len(mf.Changes) + len(mf.Binaries),
+		Binaries:        len(mf.Binaries),
+		NewFiles:        len(mf.Added),
+		DeletedFiles:    len(mf.Deleted),
+		RenamedFiles:    len(mf.Renamed),
+		MetadataChanges: len(mf.Metadata),
+	}
+
+	for _, v := range mf.Changes {
+		s.LinesChanged += v.Lines()
+	}
+
+	if t, err := time.Parse(time.RFC3339, mf.BaselineTime); err == nil {
+		s.BaselineAge = int64(now.Sub(t).Seconds())
+	}
+
+	return s
+}
+
+// summaryField is a single total of the summary
+type summaryField struct {
+	name  string
+	help  string
+	value interface{}
+}
+
+func (s *Summary) fields() []summaryField {
+	return []summaryField{
+		{"changed_files", "Number of modified files.", s.ChangedFiles},
+		{"lines_changed", "Number of changed lines of modified text files.", s.LinesChanged},
+		{"binaries", "Number of modified binary files.", s.Binaries},
+		{"new_files", "Number of files added since the baseline.", s.NewFiles},
+		{"deleted_files", "Number of files deleted since the baseline.", s.DeletedFiles},
+		{"renamed_files", "Number of files renamed since the baseline.", s.RenamedFiles},
+		{"metadata_changes", "Number of files with changed mode or owner.", s.MetadataChanges},
+		{"unreadable", "Number of files go-dts can't read.", s.UnReadable},
+		{"gt_size", "Number of files tracked only by hash due to their size.", s.GtSize},
+		{"symlinks", "Number of symlinks in the work tree.", s.Symlinks},
+		{"baseline_age", "Age of the baseline in seconds.", s.BaselineAge},
+		{"cached", "Whether the registry was unreachable and its cached copy was used.", boolToInt(s.Cached)},
+		{"cache_age", "Age of the registry cache in seconds, 0 if the registry was read.", s.CacheAge},
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Telegraf return summary as a single line of the influx line protocol with the given tags
+func (s *Summary) Telegraf(tags map[string]string) string {
+	p := NewPoint(tags)
+	p.Measurement = summaryMeasurement
+	for _, f := range s.fields() {
+		p.Fields[f.name] = f.value
+	}
+
+	return p.String()
+}
+
+// Prometheus return summary in the prometheus text exposition format, a gauge per total
+func (s *Summary) Prometheus(appName, instance string) (lines []string) {
+	labels := fmt.Sprintf(`appl_name="%s",instance="%s"`, labelEscaper.Replace(appName), labelEscaper.Replace(instance))
+	for _, f := range s.fields() {
+		name := "data_tracking_system_" + f.name
+		lines = append(lines,
+			"# HELP "+name+" "+f.help,
+			"# TYPE "+name+" gauge",
+			fmt.Sprintf("%s{%s} %d", name, labels, f.value),
+		)
+	}
+
+	return
+}
@@ -3,24 +3,77 @@ package etcd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"go.etcd.io/etcd/client"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"time"
 )
 
-var kApi client.KeysAPI
+const (
+	ApiV2 = "v2"
+	ApiV3 = "v3"
+
+	// commitKey is written last by pushes writing keys one by one
+	commitKey = "dts_settings"
+)
+
+var (
+	ErrUnsupportedApi = errors.New("unsupported etcd api, v2 or v3 expected")
+	ErrConflict       = errors.New("dts app was changed in the registry since it was fetched")
+
+	// casKeys are written by compare-and-swap, since they are shared by all instances of the host
+	casKeys = map[string]bool{commitKey: true, "emon_json": true}
+
+	kApi client.KeysAPI
+	// api is the version of etcd api used to access the registry
+	api = ApiV2
+	// settings of the registry access, set by Configure
+	settings = &Config{}
+
+	// Logger write events of the background work like lock refreshes, go-dts replaces it with its own logger
+	Logger = log.New(os.Stderr, "", 0)
+)
+
+// Configure select etcd api used by registry reads and writes, v2 is used by default,
+// tls and credentials of the config are applied to both reads and writes
+func Configure(c *Config) (err error) {
+	switch c.Api {
+	case "", ApiV2:
+		api = ApiV2
+	case ApiV3:
+		api = ApiV3
+	default:
+		return ErrUnsupportedApi
+	}
+
+	if tlsConfig, err = newTLSConfig(c); err != nil {
+		return
+	}
+
+	transport = newTransport(tlsConfig)
+	settings = c
+	return
+}
 
 func SetEtcdApi(url string) (err error) {
+	if api == ApiV3 {
+		return setV3Api(url)
+	}
+
 	cfg := client.Config{
-		Endpoints:               []string{url},
-		Transport:               client.DefaultTransport,
-		HeaderTimeoutPerRequest: time.Second,
+		Endpoints:               endpoints(url),
+		Transport:               transport,
+		Username:                settings.Username,
+		Password:                settings.Password,
+		HeaderTimeoutPerRequest: timeout(),
 	}
 
 	var c client.Client
@@ -33,8 +86,32 @@ func SetEtcdApi(url string) (err error) {
 	return
 }
 
-func (config *Etcd) FetchConfig(url string) (err error) {
-	resp, err := http.Get(url)
+// FetchConfig read recursively every key under the given prefix of the registry host,
+// example of prefix: /ps/hosts/vlg/vlg-lhrs-app1d/apps. Healthy endpoints are tried first, failed reads are retried
+func (config *Etcd) FetchConfig(url, prefix string) (err error) {
+	if api == ApiV3 {
+		return config.fetchV3(url, prefix)
+	}
+
+	return failover(url, func(endpoint string) error {
+		return config.fetchV2(endpoint, prefix)
+	})
+}
+
+func (config *Etcd) fetchV2(endpoint, prefix string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v2/keys%s?recursive=true", endpoint, prefix), nil)
+	if err != nil {
+		return
+	}
+
+	if len(settings.Username) > 0 {
+		req.SetBasicAuth(settings.Username, settings.Password)
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
 	if err != nil {
 		return
 	}
@@ -46,6 +123,11 @@ func (config *Etcd) FetchConfig(url string) (err error) {
 		return
 	}
 
+	// missing prefix means there are no apps yet, any other error must not be taken for an empty registry
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("registry responded %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
 	err = json.Unmarshal(data, &config)
 	return
 }
@@ -83,11 +165,14 @@ func (config *Etcd) FetchAppByInstance(instance string, app *App) (ok bool, err
 		// example: idDotHash = ["5118", "3049088120"]
 		idDotHash := strings.Split(key, ".")
 		if idDotHash[1] == instance {
+			app.Revisions = make(map[string]uint64)
 			for _, v := range value.Nodes {
 				// slice of key path, example: /ps/hosts/vlg/vlg-lhrs-app1d/apps/5118.3049088120/appl_id
 				kParts := strings.Split(v.Key, "/")
 				// example: k = ["appl_id", "application_name", ...]
-				switch k := kParts[len(kParts)-1]; k {
+				k := kParts[len(kParts)-1]
+				app.Revisions[k] = v.ModifiedIndex
+				switch k {
 				case "dts_settings":
 					if err = json.Unmarshal([]byte(replacer.Replace(v.Value)), &app.DtsSettings); err != nil {
 						return
@@ -121,9 +206,70 @@ func (config *Etcd) FetchAppByInstance(instance string, app *App) (ok bool, err
 	return
 }
 
+// Push write non-empty fields of the app under the given uri, v3 api writes all keys in a single transaction.
+// Json keys are written only if they weren't changed since the app was fetched, otherwise ErrConflict is returned
 func (app *App) Push(uri string) (updatedKeys []string, err error) {
-	v := reflect.ValueOf(app).Elem()
+	keys, values, err := app.keyValues(uri)
+	if err != nil {
+		return
+	}
+
+	if app.Revisions == nil {
+		app.Revisions = make(map[string]uint64)
+	}
+
+	if api == ApiV3 {
+		return app.putV3(uri, keys, values)
+	}
+
 	resp := &client.Response{}
+	for i := 0; i < len(keys); i++ {
+		name := strings.TrimPrefix(keys[i], uri)
+		opts := &client.SetOptions{}
+		if casKeys[name] {
+			if rev := app.Revisions[name]; rev > 0 {
+				opts.PrevIndex = rev
+			} else {
+				opts.PrevExist = client.PrevNoExist
+			}
+		}
+
+		err = retry(func() (err error) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout())
+			defer cancel()
+
+			resp, err = kApi.Set(ctx, keys[i], values[i], opts)
+			return
+		})
+		if isConflict(err) {
+			return updatedKeys, ErrConflict
+		}
+
+		if err != nil {
+			return
+		}
+
+		app.Revisions[name] = resp.Node.ModifiedIndex
+		updatedKeys = append(updatedKeys, fmt.Sprintf("key %s: %s=%s\n", resp.Action, keys[i], values[i]))
+	}
+
+	return
+}
+
+// isConflict report whether conditional write failed because the key was changed or created by someone else
+func isConflict(err error) bool {
+	if e, ok := err.(client.Error); ok {
+		return e.Code == client.ErrorCodeTestFailed || e.Code == client.ErrorCodeNodeExist
+	}
+
+	return false
+}
+
+// keyValues return keys and values of non-empty fields of the app, nested structures are written as json.
+// dts_settings is the last key, so when keys are written one by one it commits the push: if a write of emon_json
+// fails by a conflict, app_list in the registry is still the previous one and the rebased push writes both again
+func (app *App) keyValues(uri string) (keys, values []string, err error) {
+	v := reflect.ValueOf(app).Elem()
 	for i := 0; i < v.NumField(); i++ {
 		// get json key
 		key := v.Type().Field(i).Tag.Get("json")
@@ -131,12 +277,8 @@ func (app *App) Push(uri string) (updatedKeys []string, err error) {
 		switch v.Field(i).Interface().(type) {
 		case string:
 			if v.Field(i).Len() > 0 {
-				resp, err = kApi.Set(context.Background(), uri+key, v.Field(i).String(), nil)
-				if err != nil {
-					return
-				}
-
-				updatedKeys = append(updatedKeys, fmt.Sprintf("key %s: %s=%s\n", resp.Action, uri+key, v.Field(i).String()))
+				keys = append(keys, uri+key)
+				values = append(values, v.Field(i).String())
 			}
 		case *EmonJson:
 			if cmp.Equal(&app.EmonJson, &EmonJson{}) {
@@ -149,12 +291,8 @@ func (app *App) Push(uri string) (updatedKeys []string, err error) {
 				return
 			}
 
-			resp, err = kApi.Set(context.Background(), uri+key, string(buf), nil)
-			if err != nil {
-				return
-			}
-
-			updatedKeys = append(updatedKeys, fmt.Sprintf("key %s: %s=%v\n", resp.Action, uri+key, v.Field(i).Elem().Interface()))
+			keys = append(keys, uri+key)
+			values = append(values, string(buf))
 		case *DtsSettings:
 			if cmp.Equal(&app.DtsSettings, &DtsSettings{}) {
 				continue
@@ -166,31 +304,51 @@ func (app *App) Push(uri string) (updatedKeys []string, err error) {
 				return
 			}
 
-			resp, err = kApi.Set(context.Background(), uri+key, string(buf), nil)
-			if err != nil {
-				return
-			}
+			keys = append(keys, uri+key)
+			values = append(values, string(buf))
+		}
+	}
 
-			updatedKeys = append(updatedKeys, fmt.Sprintf("key %s: %s=%v\n", resp.Action, uri+key, v.Field(i).Elem().Interface()))
+	for i := 0; i < len(keys)-1; i++ {
+		if keys[i] == uri+commitKey {
+			k, v := keys[i], values[i]
+			keys = append(append(keys[:i], keys[i+1:]...), k)
+			values = append(append(values[:i], values[i+1:]...), v)
+			break
 		}
 	}
+
 	return
 }
 
-// SetDtsSettings set or update dts_settings struct
+// SetDtsSettings set or update dts_settings struct, tracking settings of existing instance are preserved
 func (ds *DtsSettings) SetDtsSettings(appDir, appName, workTree, dtsDir, instance string) {
 	gitDir := filepath.Join(dtsDir, instance)
 	if ds.AppList == nil {
 		ds.AppList = map[string]*Instance{}
 	}
 
-	ds.AppList[instance] = &Instance{
+	v := &Instance{
 		AppDir:   appDir,
 		AppName:  appName,
 		WorkTree: workTree,
 		GitDir:   gitDir,
 		Enabled:  true,
 	}
+
+	if old, ok := ds.AppList[instance]; ok {
+		v.MaxFileSize = old.MaxFileSize
+		v.Include = old.Include
+		v.Exclude = old.Exclude
+	}
+
+	ds.AppList[instance] = v
+	ds.Updated = time.Now().Format(time.RFC3339)
+}
+
+// RemoveInstance remove instance from app_list
+func (ds *DtsSettings) RemoveInstance(instance string) {
+	delete(ds.AppList, instance)
 	ds.Updated = time.Now().Format(time.RFC3339)
 }
 
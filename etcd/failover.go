@@ -0,0 +1,140 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/etcd/client"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetries = 2
+	defaultBackoff = 500 * time.Millisecond
+	defaultTimeout = 5 * time.Second
+)
+
+var ErrUnhealthy = errors.New("etcd endpoint is unhealthy")
+
+// endpoints return configured endpoints followed by the given one if it isn't among them
+func endpoints(url string) []string {
+	eps := make([]string, 0, len(settings.Endpoints)+1)
+	for i := 0; i < len(settings.Endpoints); i++ {
+		if settings.Endpoints[i] == url {
+			url = ""
+		}
+
+		eps = append(eps, settings.Endpoints[i])
+	}
+
+	if len(url) > 0 {
+		eps = append(eps, url)
+	}
+
+	return eps
+}
+
+// timeout return time limit of a single request to the registry
+func timeout() time.Duration {
+	if settings.Timeout > 0 {
+		return settings.Timeout
+	}
+
+	return defaultTimeout
+}
+
+// maxRetries return number of retries of a failed request, zero disables them
+func maxRetries() int {
+	if settings.Retries != nil && *settings.Retries >= 0 {
+		return *settings.Retries
+	}
+
+	return defaultRetries
+}
+
+// retry call fn until it succeeds, number of retries is bounded and delay between them is doubled each time.
+// Errors returned by the registry itself are not retried
+func retry(fn func() error) (err error) {
+	retries, backoff := maxRetries(), defaultBackoff
+	if settings.Backoff > 0 {
+		backoff = settings.Backoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt == retries {
+			return
+		}
+
+		// registry has answered, so retrying won't change the answer
+		if _, ok := err.(client.Error); ok {
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// failover call fn with each endpoint in turn until it succeeds, the whole round is retried on failure.
+// Health only orders endpoints, since etcd behind a proxy may have no /health handler
+func failover(url string, fn func(endpoint string) error) error {
+	eps := endpoints(url)
+	return retry(func() (err error) {
+		ordered := byHealth(eps)
+		for i := 0; i < len(ordered); i++ {
+			if err = fn(ordered[i]); err == nil {
+				return
+			}
+		}
+
+		return
+	})
+}
+
+// byHealth return healthy endpoints followed by unhealthy ones, the order of endpoints is kept otherwise
+func byHealth(eps []string) []string {
+	if len(eps) < 2 {
+		return eps
+	}
+
+	ordered := make([]string, 0, len(eps))
+	var unhealthy []string
+	for i := 0; i < len(eps); i++ {
+		if err := healthy(eps[i]); err != nil {
+			unhealthy = append(unhealthy, eps[i])
+			continue
+		}
+
+		ordered = append(ordered, eps[i])
+	}
+
+	return append(ordered, unhealthy...)
+}
+
+// healthy check endpoint by its /health handler
+func healthy(endpoint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	health := &struct {
+		Health string `json:"health"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(health); err != nil || health.Health != "true" {
+		return ErrUnhealthy
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package etcd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	zero, one, negative := 0, 1, -1
+	tests := []struct {
+		name    string
+		retries *int
+		calls   int
+	}{
+		{name: "default", retries: nil, calls: defaultRetries + 1},
+		{name: "disabled", retries: &zero, calls: 1},
+		{name: "single retry", retries: &one, calls: 2},
+		{name: "negative means default", retries: &negative, calls: defaultRetries + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings = &Config{Retries: tt.retries, Backoff: time.Millisecond}
+			defer func() { settings = &Config{} }()
+
+			calls := 0
+			err := retry(func() error {
+				calls++
+				return errors.New("connection refused")
+			})
+
+			if err == nil || calls != tt.calls {
+				t.Errorf("retry() = %v after %d calls, want error after %d", err, calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestFailoverTryUnhealthyEndpoints(t *testing.T) {
+	settings = &Config{Retries: new(int)}
+	defer func() { settings = &Config{} }()
+
+	// proxy without /health handler
+	noHealth := httptest.NewServer(http.NotFoundHandler())
+	defer noHealth.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"health":"true"}`))
+	}))
+	defer healthy.Close()
+
+	var tried []string
+	err := failover(noHealth.URL, func(endpoint string) error {
+		tried = append(tried, endpoint)
+		return nil
+	})
+
+	if err != nil || len(tried) != 1 || tried[0] != noHealth.URL {
+		t.Errorf("failover() = %v, tried %q, want the single endpoint used", err, tried)
+	}
+
+	// healthy endpoint goes first, unhealthy one is still tried when it fails
+	settings.Endpoints = []string{noHealth.URL}
+	tried = nil
+	err = failover(healthy.URL, func(endpoint string) error {
+		tried = append(tried, endpoint)
+		if endpoint == healthy.URL {
+			return errors.New("connection reset")
+		}
+
+		return nil
+	})
+
+	if err != nil || len(tried) != 2 || tried[0] != healthy.URL || tried[1] != noHealth.URL {
+		t.Errorf("failover() = %v, tried %q, want %s then %s", err, tried, healthy.URL, noHealth.URL)
+	}
+}
@@ -0,0 +1,334 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// lockPollInterval is how often a busy lock file is tried again
+	lockPollInterval = 100 * time.Millisecond
+	// revisionsFile keep revisions of the app keys, they are counters increased by every write of the key
+	revisionsFile = ".revisions"
+	// putLockFile serialize writes of the app keys
+	putLockFile = ".lock"
+)
+
+// fileRegistry keep apps in a local directory tree mirroring registry keys: a directory per app and a file per key,
+// json keys are kept as json documents. Files starting with a dot are not keys
+type fileRegistry struct {
+	root string
+}
+
+func (r *fileRegistry) path(key string) string {
+	return filepath.Join(r.root, filepath.FromSlash(key))
+}
+
+// Apps read the tree the same way etcd v2 api returns it, missing prefix means there are no apps yet
+func (r *fileRegistry) Apps(prefix string) (*Etcd, error) {
+	config := &Etcd{Action: "get", Node: Node{Key: prefix}}
+	dirs, err := ioutil.ReadDir(r.path(prefix))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		appKey := prefix + "/" + d.Name()
+		files, err := ioutil.ReadDir(r.path(appKey))
+		if err != nil {
+			return nil, err
+		}
+
+		// revisions are read before values, so a value written concurrently has a newer revision than the read one
+		// and conditional writes based on this read fail instead of overwriting it
+		revs, err := r.revisions(appKey)
+		if err != nil {
+			return nil, err
+		}
+
+		node := Node{Key: appKey}
+		for _, f := range files {
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+
+			key := appKey + "/" + f.Name()
+			b, err := ioutil.ReadFile(r.path(key))
+			if err != nil {
+				return nil, err
+			}
+
+			node.Nodes = append(node.Nodes, Node{Key: key, Value: string(b), ModifiedIndex: revs[f.Name()]})
+		}
+
+		config.Node.Nodes = append(config.Node.Nodes, node)
+	}
+
+	return config, nil
+}
+
+func (r *fileRegistry) App(prefix, instance string, app *App) (bool, error) {
+	return fetchApp(r, prefix, instance, app)
+}
+
+// Put write keys of the app under its own lock, so checking revisions and writing are done at once.
+// Every key is written to a temporary file and renamed, so readers never see a partially written key,
+// revisions are increased after the values are written
+func (r *fileRegistry) Put(uri string, app *App) (updatedKeys []string, err error) {
+	keys, values, err := app.keyValues(uri)
+	if err != nil {
+		return
+	}
+
+	appKey := strings.TrimSuffix(uri, "/")
+	if err = os.MkdirAll(r.path(appKey), 0755); err != nil {
+		return
+	}
+
+	l, err := acquireLockFile(r.path(appKey+"/"+putLockFile), fmt.Sprintf("%d", os.Getpid()), timeout())
+	if err != nil {
+		return
+	}
+	defer l.Unlock()
+
+	revs, err := r.revisions(appKey)
+	if err != nil {
+		return
+	}
+
+	if app.Revisions == nil {
+		app.Revisions = make(map[string]uint64)
+	}
+
+	for i := 0; i < len(keys); i++ {
+		name := strings.TrimPrefix(keys[i], uri)
+		if casKeys[name] && revs[name] != app.Revisions[name] {
+			return nil, ErrConflict
+		}
+	}
+
+	for i := 0; i < len(keys); i++ {
+		if err = writeFile(r.path(keys[i]), []byte(values[i])); err != nil {
+			return
+		}
+
+		updatedKeys = append(updatedKeys, fmt.Sprintf("key set: %s=%s\n", keys[i], values[i]))
+	}
+
+	for i := 0; i < len(keys); i++ {
+		revs[strings.TrimPrefix(keys[i], uri)]++
+	}
+
+	b, err := json.Marshal(revs)
+	if err != nil {
+		return
+	}
+
+	if err = writeFile(r.path(appKey+"/"+revisionsFile), b); err != nil {
+		return
+	}
+
+	for i := 0; i < len(keys); i++ {
+		name := strings.TrimPrefix(keys[i], uri)
+		app.Revisions[name] = revs[name]
+	}
+
+	return
+}
+
+// revisions return revisions of the app keys, keys which were never written by Put have revision 0
+func (r *fileRegistry) revisions(appKey string) (map[string]uint64, error) {
+	revs := make(map[string]uint64)
+	b, err := ioutil.ReadFile(r.path(appKey + "/" + revisionsFile))
+	if os.IsNotExist(err) {
+		return revs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &revs)
+	return revs, err
+}
+
+// writeFile replace the file by a temporary one, so the file is never seen partially written
+func writeFile(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// fileLock is a lock file created exclusively, its modification time is refreshed while the lock is held,
+// so the lock left by a dead go-dts expires like the ttl key of etcd
+type fileLock struct {
+	path  string
+	owner string
+	stop  chan struct{}
+}
+
+// Lock wait for the lock file until it is removed or expires, or lock timeout of the config is over
+func (r *fileRegistry) Lock(key, owner string) (Locker, error) {
+	wait := settings.LockTimeout
+	if wait <= 0 {
+		wait = defaultLockTimeout
+	}
+
+	path := r.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	return acquireLockFile(path, owner, wait)
+}
+
+// acquireLockFile create the lock file with the owner as its content, waiting up to wait for the current one
+// to be removed or to expire
+func acquireLockFile(path, owner string, wait time.Duration) (*fileLock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	l := &fileLock{path: path, owner: owner, stop: make(chan struct{})}
+	for {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(owner)
+			if cErr := f.Close(); err == nil {
+				err = cErr
+			}
+
+			if err != nil {
+				_ = os.Remove(l.path)
+				return nil, err
+			}
+
+			go l.keepAlive()
+			return l, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if breakExpired(l.path) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrLockTimeout
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// breakExpired move the expired lock file away and report whether it was done. Lock is moved by rename, which is
+// atomic, and checked again after it. If another waiter took the lock in between, its fresh lock is put back
+func breakExpired(path string) bool {
+	owner, expired := lockState(path)
+	if !expired {
+		return false
+	}
+
+	stale := fmt.Sprintf("%s.%d.stale", path, os.Getpid())
+	if err := os.Rename(path, stale); err != nil {
+		return false
+	}
+
+	defer os.Remove(stale)
+	if o, ok := lockState(stale); ok && o == owner {
+		return true
+	}
+
+	// link fails if the lock was created again, then that one is kept
+	_ = os.Link(stale, path)
+	return false
+}
+
+// lockState return owner of the lock file and whether it wasn't refreshed for the whole ttl
+func lockState(path string) (owner string, expired bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	return string(b), time.Since(fi.ModTime()) > lockTTL
+}
+
+// Err return ErrLockLost if the lock file was removed or taken over
+func (l *fileLock) Err() error {
+	if b, err := ioutil.ReadFile(l.path); err != nil || string(b) != l.owner {
+		return ErrLockLost
+	}
+
+	return nil
+}
+
+// Unlock release the lock if it is still held by the owner
+func (l *fileLock) Unlock() error {
+	close(l.stop)
+
+	if err := l.Err(); err != nil {
+		return err
+	}
+
+	return os.Remove(l.path)
+}
+
+// keepAlive refresh modification time of the lock file until the lock is released or lost
+func (l *fileLock) keepAlive() {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.Err(); err != nil {
+				Logger.Printf("lock %s is lost\n", l.path)
+				return
+			}
+
+			now := time.Now()
+			if err := os.Chtimes(l.path, now, now); err != nil {
+				Logger.Printf("can't refresh lock %s: %s\n", l.path, err)
+			}
+		}
+	}
+}
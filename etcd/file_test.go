@@ -0,0 +1,174 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const testPrefix = "/ps/hosts/test/host/apps"
+
+// testFileRegistry return file registry kept in a temporary dir, lock timeout is short to keep tests fast
+func testFileRegistry(t *testing.T) Registry {
+	settings = &Config{Backend: BackendFile, Path: t.TempDir(), LockTimeout: 300 * time.Millisecond}
+	t.Cleanup(func() { settings = &Config{} })
+
+	r, err := NewRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestFileRegistryRoundTrip(t *testing.T) {
+	r := testFileRegistry(t)
+
+	config, err := r.Apps(testPrefix)
+	if err != nil || len(config.Node.Nodes) != 0 {
+		t.Fatalf("Apps() of empty registry = %+v, %v", config, err)
+	}
+
+	app := testApp(map[string]string{"1": "/a"})
+	app.Stand = "test"
+	if _, err = r.Put(testPrefix+"/5118.42/", app); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err = r.Apps(testPrefix)
+	if err != nil || len(config.Node.Nodes) != 1 {
+		t.Fatalf("Apps() = %+v, %v", config, err)
+	}
+
+	got := &App{}
+	ok, err := r.App(testPrefix, "42", got)
+	if err != nil || !ok {
+		t.Fatalf("App() = %v, %v", ok, err)
+	}
+
+	if got.Stand != "test" || got.ApplicationName != app.ApplicationName || got.DtsSettings.AppList["1"].WorkTree != "/a" {
+		t.Errorf("App() = %+v, want %+v", got, app)
+	}
+
+	if got.Revisions[commitKey] != app.Revisions[commitKey] || got.Revisions[commitKey] == 0 {
+		t.Errorf("revision of %s = %d, written %d", commitKey, got.Revisions[commitKey], app.Revisions[commitKey])
+	}
+
+	if ok, err = r.App(testPrefix, "43", &App{}); err != nil || ok {
+		t.Errorf("App() of missing instance = %v, %v", ok, err)
+	}
+}
+
+func TestFileRegistryConflict(t *testing.T) {
+	r := testFileRegistry(t)
+	uri := testPrefix + "/5118.42/"
+	if _, err := r.Put(uri, testApp(map[string]string{"1": "/a"})); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := &App{}, &App{}
+	for _, app := range []*App{first, second} {
+		if _, err := r.App(testPrefix, "42", app); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first.DtsSettings.AppList["2"] = &Instance{WorkTree: "/b"}
+	if _, err := r.Put(uri, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second.DtsSettings.AppList["3"] = &Instance{WorkTree: "/c"}
+	if _, err := r.Put(uri, second); err != ErrConflict {
+		t.Errorf("Put() of stale app = %v, want %v", err, ErrConflict)
+	}
+
+	// app written by the first put is kept and can be written again
+	if _, err := r.Put(uri, first); err != nil {
+		t.Errorf("Put() of fresh app = %v", err)
+	}
+}
+
+func TestFileRegistryLock(t *testing.T) {
+	r := testFileRegistry(t)
+	key := "/ps/hosts/test/host/go-dts.lock"
+
+	l, err := r.Lock(key, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Lock(key, "second"); err != ErrLockTimeout {
+		t.Fatalf("Lock() of busy lock = %v, want %v", err, ErrLockTimeout)
+	}
+
+	if err = l.Err(); err != nil {
+		t.Errorf("Err() of held lock = %v", err)
+	}
+
+	if err = l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = r.Lock(key, "second")
+	if err != nil {
+		t.Fatalf("Lock() of released lock = %v", err)
+	}
+
+	if err = l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileRegistryLockExpiry(t *testing.T) {
+	r := testFileRegistry(t)
+	key := "/ps/hosts/test/host/go-dts.lock"
+
+	dead, err := r.Lock(key, "dead")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := r.(*fileRegistry).path(key)
+	old := time.Now().Add(-2 * lockTTL)
+	if err = os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := r.Lock(key, "alive")
+	if err != nil {
+		t.Fatalf("Lock() of expired lock = %v", err)
+	}
+
+	if b, _ := ioutil.ReadFile(path); string(b) != "alive" {
+		t.Errorf("lock owner = %q, want alive", b)
+	}
+
+	if err = dead.Err(); err != ErrLockLost {
+		t.Errorf("Err() of expired lock = %v, want %v", err, ErrLockLost)
+	}
+
+	if err = dead.Unlock(); err == nil {
+		t.Error("Unlock() of expired lock removed the lock taken over")
+	}
+
+	if err = l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreakExpiredKeepFreshLock(t *testing.T) {
+	path := t.TempDir() + "/go-dts.lock"
+	if err := ioutil.WriteFile(path, []byte("fresh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if breakExpired(path) {
+		t.Error("fresh lock is broken")
+	}
+
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "fresh" {
+		t.Errorf("lock = %q, %v, want fresh", b, err)
+	}
+}
@@ -0,0 +1,187 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/client"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+	"sync"
+	"time"
+)
+
+const (
+	lockTTL            = 60 * time.Second
+	defaultLockTimeout = 2 * time.Minute
+)
+
+var (
+	ErrLockTimeout = errors.New("timed out waiting for the registry lock")
+	ErrLockLost    = errors.New("registry lock expired or was taken over while it was held")
+)
+
+// HostLock is a lock kept in the registry. It is bound to a lease (v3) or a key ttl (v2) which is kept alive
+// while the lock is held, so the lock expires if go-dts dies
+type HostLock struct {
+	key   string
+	owner string
+	stop  chan struct{}
+
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+
+	// mu guard the time of the latest successful refresh of the v2 lock and the reason it was lost
+	mu        sync.Mutex
+	refreshed time.Time
+	lost      error
+}
+
+// AcquireLock wait for the lock at the given key until it is free or lock timeout of the config is over,
+// owner is written as the lock value for troubleshooting
+func AcquireLock(key, owner string) (l *HostLock, err error) {
+	wait := settings.LockTimeout
+	if wait <= 0 {
+		wait = defaultLockTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	l = &HostLock{key: key, owner: owner, stop: make(chan struct{})}
+	if api == ApiV3 {
+		err = l.lockV3(ctx)
+	} else {
+		err = l.lockV2(ctx)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		err = ErrLockTimeout
+	}
+
+	return
+}
+
+// Err return ErrLockLost if the lock is not held anymore, writes protected by the lock must be stopped then
+func (l *HostLock) Err() error {
+	if l.session != nil {
+		select {
+		case <-l.session.Done():
+			return ErrLockLost
+		default:
+			return nil
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost == nil && time.Since(l.refreshed) >= lockTTL {
+		l.lost = ErrLockLost
+	}
+
+	return l.lost
+}
+
+// Unlock release the lock and stop keeping it alive
+func (l *HostLock) Unlock() error {
+	close(l.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout())
+	defer cancel()
+
+	if l.session != nil {
+		defer l.session.Close()
+		return l.mutex.Unlock(ctx)
+	}
+
+	_, err := kApi.Delete(ctx, l.key, &client.DeleteOptions{PrevValue: l.owner})
+	return err
+}
+
+// lockV3 grant the lease within the lock timeout, the session keeps it alive until the lock is released.
+// Owner is written as the value of the mutex key while the mutex is held
+func (l *HostLock) lockV3(ctx context.Context) (err error) {
+	lease, err := v3Client.Grant(ctx, int64(lockTTL.Seconds()))
+	if err != nil {
+		return
+	}
+
+	l.session, err = concurrency.NewSession(v3Client, concurrency.WithLease(lease.ID))
+	if err != nil {
+		_, _ = v3Client.Revoke(context.Background(), lease.ID)
+		return
+	}
+
+	l.mutex = concurrency.NewMutex(l.session, l.key)
+	if err = l.mutex.Lock(ctx); err == nil {
+		_, err = v3Client.Txn(ctx).If(l.mutex.IsOwner()).Then(clientv3.OpPut(l.mutex.Key(), l.owner, clientv3.WithLease(lease.ID))).Commit()
+		if err != nil {
+			_ = l.mutex.Unlock(context.Background())
+		}
+	}
+
+	if err != nil {
+		l.session.Close()
+		l.session = nil
+	}
+
+	return
+}
+
+// lockV2 create the key if it doesn't exist, otherwise wait for its deletion or expiration
+func (l *HostLock) lockV2(ctx context.Context) error {
+	for {
+		_, err := kApi.Set(ctx, l.key, l.owner, &client.SetOptions{PrevExist: client.PrevNoExist, TTL: lockTTL})
+		if err == nil {
+			l.refreshed = time.Now()
+			go l.keepAliveV2()
+			return nil
+		}
+
+		e, ok := err.(client.Error)
+		if !ok || e.Code != client.ErrorCodeNodeExist {
+			return err
+		}
+
+		// watch request may fail by the request timeout, then the lock is just tried again
+		_, _ = kApi.Watcher(l.key, &client.WatcherOptions{AfterIndex: e.Index}).Next(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// keepAliveV2 refresh ttl of the lock key until the lock is released. Failed refresh is tried again on the next tick,
+// the lock is lost if the key was deleted or taken over, or it wasn't refreshed for the whole ttl
+func (l *HostLock) keepAliveV2() {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout())
+			_, err := kApi.Set(ctx, l.key, "", &client.SetOptions{PrevValue: l.owner, TTL: lockTTL, Refresh: true})
+			cancel()
+
+			l.mu.Lock()
+			if err == nil {
+				l.refreshed = time.Now()
+			} else if _, ok := err.(client.Error); ok || time.Since(l.refreshed) >= lockTTL {
+				l.lost = ErrLockLost
+			}
+			lost := l.lost
+			l.mu.Unlock()
+
+			if err != nil {
+				Logger.Printf("can't refresh registry lock %s: %s\n", l.key, err)
+			}
+
+			if lost != nil {
+				Logger.Printf("registry lock %s is lost\n", l.key)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package etcd
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"time"
+)
+
+// Copy return deep copy of the app
+func (app *App) Copy() (*App, error) {
+	b, err := json.Marshal(app)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &App{}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	if app.Revisions != nil {
+		c.Revisions = make(map[string]uint64, len(app.Revisions))
+		for k, v := range app.Revisions {
+			c.Revisions[k] = v
+		}
+	}
+
+	return c, nil
+}
+
+// Rebase apply instance-level changes made to the app since base was fetched onto the latest app fetched
+// from the registry: instances added, changed or removed in app_list and their measurements in emon_json.
+// Changes of other instances made concurrently are kept
+func Rebase(app, base, latest *App) *App {
+	if latest.DtsSettings == nil {
+		latest.DtsSettings = &DtsSettings{}
+	}
+
+	if latest.EmonJson == nil {
+		latest.EmonJson = &EmonJson{}
+	}
+
+	if base.DtsSettings == nil {
+		base.DtsSettings = &DtsSettings{}
+	}
+
+	if base.EmonJson == nil {
+		base.EmonJson = &EmonJson{}
+	}
+
+	ds := app.DtsSettings
+	for k, v := range ds.AppList {
+		if old, ok := base.DtsSettings.AppList[k]; !ok || !cmp.Equal(old, v) {
+			if latest.DtsSettings.AppList == nil {
+				latest.DtsSettings.AppList = map[string]*Instance{}
+			}
+
+			latest.DtsSettings.AppList[k] = v
+		}
+	}
+
+	for k := range base.DtsSettings.AppList {
+		if _, ok := ds.AppList[k]; !ok {
+			delete(latest.DtsSettings.AppList, k)
+		}
+	}
+
+	latest.DtsSettings.Updated = time.Now().Format(time.RFC3339)
+
+	ej := app.EmonJson
+	for _, m := range ej.Measurements {
+		instance := measurementInstance(m)
+		if base.EmonJson.findMeasurement(instance) == nil && latest.EmonJson.findMeasurement(instance) == nil {
+			latest.EmonJson.Measurements = append(latest.EmonJson.Measurements, m)
+		}
+	}
+
+	for _, m := range base.EmonJson.Measurements {
+		if instance := measurementInstance(m); ej.findMeasurement(instance) == nil {
+			latest.EmonJson.RemoveMeasurementByInstance(instance)
+		}
+	}
+
+	if latest.EmonJson.ApplId == 0 {
+		latest.EmonJson.ApplId, latest.EmonJson.Description = ej.ApplId, ej.Description
+		latest.EmonJson.Product, latest.EmonJson.Service = ej.Product, ej.Service
+	}
+
+	latest.ApplId, latest.ApplicationName, latest.Stand = app.ApplId, app.ApplicationName, app.Stand
+	return latest
+}
+
+// findMeasurement return measurement of the instance or nil if there is no such one
+func (ej *EmonJson) findMeasurement(instance string) *Measurement {
+	for i := 0; i < len(ej.Measurements); i++ {
+		if measurementInstance(ej.Measurements[i]) == instance {
+			return ej.Measurements[i]
+		}
+	}
+
+	return nil
+}
+
+// measurementInstance return instance checked by the measurement, instance is the last argument of the command
+func measurementInstance(m *Measurement) string {
+	if m.Configuration == nil || len(m.Configuration.Commands) == 0 {
+		return ""
+	}
+
+	s := strings.Split(m.Configuration.Commands[0], " ")
+	return s[len(s)-1]
+}
@@ -0,0 +1,135 @@
+package etcd
+
+import (
+	"sort"
+	"testing"
+)
+
+// testApp return dts app with the given instances in app_list and a measurement per instance
+func testApp(instances map[string]string) *App {
+	app := &App{ApplId: "5118", ApplicationName: "go-dts", DtsSettings: &DtsSettings{AppList: map[string]*Instance{}}, EmonJson: &EmonJson{}}
+	for k, workTree := range instances {
+		app.DtsSettings.AppList[k] = &Instance{AppName: "app", WorkTree: workTree, Enabled: true}
+		app.EmonJson.Measurements = append(app.EmonJson.Measurements, &Measurement{
+			Name:          "data-tracking-system",
+			Configuration: &Configuration{Commands: []string{"go-dts -a status -i " + k}},
+		})
+	}
+
+	return app
+}
+
+func TestRebase(t *testing.T) {
+	tests := []struct {
+		name string
+		// base is app_list as it was fetched, app is app_list changed by this run and latest is app_list
+		// changed concurrently by another run
+		base, app, latest map[string]string
+		want              map[string]string
+	}{
+		{
+			name:   "concurrent add",
+			base:   map[string]string{"1": "/a"},
+			app:    map[string]string{"1": "/a", "2": "/b"},
+			latest: map[string]string{"1": "/a", "3": "/c"},
+			want:   map[string]string{"1": "/a", "2": "/b", "3": "/c"},
+		},
+		{
+			name:   "concurrent remove",
+			base:   map[string]string{"1": "/a", "2": "/b"},
+			app:    map[string]string{"2": "/b"},
+			latest: map[string]string{"1": "/a"},
+			want:   map[string]string{},
+		},
+		{
+			name:   "remove and add",
+			base:   map[string]string{"1": "/a"},
+			app:    map[string]string{},
+			latest: map[string]string{"1": "/a", "3": "/c"},
+			want:   map[string]string{"3": "/c"},
+		},
+		{
+			name:   "same key edit",
+			base:   map[string]string{"1": "/a"},
+			app:    map[string]string{"1": "/a/versions/2"},
+			latest: map[string]string{"1": "/a/versions/3"},
+			want:   map[string]string{"1": "/a/versions/2"},
+		},
+		{
+			name:   "already written",
+			base:   map[string]string{"1": "/a"},
+			app:    map[string]string{"1": "/a", "2": "/b"},
+			latest: map[string]string{"1": "/a", "2": "/b"},
+			want:   map[string]string{"1": "/a", "2": "/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Rebase(testApp(tt.app), testApp(tt.base), testApp(tt.latest))
+
+			if len(got.DtsSettings.AppList) != len(tt.want) {
+				t.Fatalf("app_list = %v, want %v", got.DtsSettings.AppList, tt.want)
+			}
+
+			for k, workTree := range tt.want {
+				if v, ok := got.DtsSettings.AppList[k]; !ok || v.WorkTree != workTree {
+					t.Errorf("instance %s = %+v, want work tree %s", k, v, workTree)
+				}
+			}
+
+			var instances, wantInstances []string
+			for _, m := range got.EmonJson.Measurements {
+				instances = append(instances, measurementInstance(m))
+			}
+
+			for k := range tt.want {
+				wantInstances = append(wantInstances, k)
+			}
+
+			sort.Strings(instances)
+			sort.Strings(wantInstances)
+			if len(instances) != len(wantInstances) {
+				t.Fatalf("measurements of %v, want %v", instances, wantInstances)
+			}
+
+			for i := 0; i < len(instances); i++ {
+				if instances[i] != wantInstances[i] {
+					t.Errorf("measurements of %v, want %v", instances, wantInstances)
+				}
+			}
+		})
+	}
+}
+
+func TestCopy(t *testing.T) {
+	app := testApp(map[string]string{"1": "/a"})
+	app.Revisions = map[string]uint64{"dts_settings": 7}
+
+	c, err := app.Copy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.DtsSettings.AppList["1"].WorkTree = "/b"
+	c.EmonJson.Measurements = nil
+	c.Revisions["dts_settings"] = 8
+
+	if app.DtsSettings.AppList["1"].WorkTree != "/a" || len(app.EmonJson.Measurements) != 1 || app.Revisions["dts_settings"] != 7 {
+		t.Errorf("copy shares data with the app: %+v", app)
+	}
+}
+
+func TestKeyValuesCommitKeyIsLast(t *testing.T) {
+	app := testApp(map[string]string{"1": "/a"})
+	app.EmonJson.ApplId = 5118
+
+	keys, values, err := app.keyValues("/apps/5118.1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != len(values) || keys[len(keys)-1] != "/apps/5118.1/"+commitKey {
+		t.Errorf("keys = %v, want %s last", keys, commitKey)
+	}
+}
@@ -0,0 +1,96 @@
+package etcd
+
+import "errors"
+
+const (
+	BackendEtcd = "etcd"
+	BackendFile = "file"
+)
+
+var (
+	ErrUnsupportedBackend = errors.New("unsupported registry backend, etcd or file expected")
+	ErrPathIsNotSet       = errors.New("path of the file registry is not set")
+)
+
+// Registry is a storage of the host apps settings. Keys are laid out the same way by every backend:
+// <prefix>/<appl_id>.<instance>/<key>, example: /ps/hosts/vlg/vlg-lhrs-app1d/apps/5118.3049088120/dts_settings
+type Registry interface {
+	// Apps read every app stored under the prefix
+	Apps(prefix string) (*Etcd, error)
+	// App read a single app stored under the prefix by its instance, ok is false if there is no such app
+	App(prefix, instance string, app *App) (ok bool, err error)
+	// Put write non-empty fields of the app under the uri, json keys are written only if they weren't changed
+	// since the app was read, otherwise ErrConflict is returned
+	Put(uri string, app *App) (updatedKeys []string, err error)
+	// Lock wait for the host lock at the given key, owner is kept as the lock value for troubleshooting
+	Lock(key, owner string) (Locker, error)
+}
+
+// Locker is a held host lock
+type Locker interface {
+	// Err return ErrLockLost if the lock expired or was taken over, writes protected by the lock must be stopped then
+	Err() error
+	Unlock() error
+}
+
+// NewRegistry return registry of the backend selected by Configure, url is the etcd endpoint derived from
+// the host name, it is not used by the file backend
+func NewRegistry(url string) (Registry, error) {
+	switch settings.Backend {
+	case "", BackendEtcd:
+		return &etcdRegistry{url: url}, nil
+	case BackendFile:
+		if len(settings.Path) == 0 {
+			return nil, ErrPathIsNotSet
+		}
+
+		return &fileRegistry{root: settings.Path}, nil
+	default:
+		return nil, ErrUnsupportedBackend
+	}
+}
+
+// etcdRegistry keep apps in the etcd cluster
+type etcdRegistry struct {
+	url string
+}
+
+func (r *etcdRegistry) Apps(prefix string) (*Etcd, error) {
+	config := &Etcd{}
+	if err := config.FetchConfig(r.url, prefix); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (r *etcdRegistry) App(prefix, instance string, app *App) (bool, error) {
+	return fetchApp(r, prefix, instance, app)
+}
+
+func (r *etcdRegistry) Put(uri string, app *App) ([]string, error) {
+	if err := SetEtcdApi(r.url); err != nil {
+		return nil, err
+	}
+
+	return app.Push(uri)
+}
+
+func (r *etcdRegistry) Lock(key, owner string) (Locker, error) {
+	if err := SetEtcdApi(r.url); err != nil {
+		return nil, err
+	}
+
+	return AcquireLock(key, owner)
+}
+
+// fetchApp read apps under the prefix and pick one of them, neither backend can read a single app cheaper
+// since the app key contains appl_id in addition to the instance
+func fetchApp(r Registry, prefix, instance string, app *App) (bool, error) {
+	config, err := r.Apps(prefix)
+	if err != nil {
+		return false, err
+	}
+
+	return config.FetchAppByInstance(instance, app)
+}
@@ -1,5 +1,34 @@
 package etcd
 
+import "time"
+
+// Config describe access to the registry host, it is read from config/etcd.yml
+type Config struct {
+	// Backend is the storage of the registry: etcd (default) or file
+	Backend string `yaml:"backend,omitempty"`
+	// Path is the root dir of the file backend
+	Path string `yaml:"path,omitempty"`
+	// Api is the version of etcd api: v2 (default) or v3
+	Api string `yaml:"api"`
+	// CaFile, CertFile and KeyFile enable tls when CA or client certificate is set
+	CaFile   string `yaml:"ca_file,omitempty"`
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// Username and Password enable etcd authentication
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Endpoints are tried in turn before the one derived from the host name
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// Retries limit retries of a failed request, unset or negative means the default 2, 0 disables retries
+	Retries *int `yaml:"retries,omitempty"`
+	// Backoff is the delay before the first retry, it is doubled each time
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+	// Timeout limit a single request
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// LockTimeout limit waiting for the host lock taken by deploy, init and redeploy
+	LockTimeout time.Duration `yaml:"lock_timeout,omitempty"`
+}
+
 // ETCD structure
 type Etcd struct {
 	Action string `json:"action"`
@@ -7,9 +36,10 @@ type Etcd struct {
 }
 
 type Node struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
-	Nodes []Node `json:"nodes"`
+	Key           string `json:"key"`
+	Value         string `json:"value"`
+	Nodes         []Node `json:"nodes"`
+	ModifiedIndex uint64 `json:"modifiedIndex,omitempty"`
 }
 
 // Application structure
@@ -24,6 +54,8 @@ type App struct {
 	Stand           string       `json:"stand,omitempty"`
 	DtsSettings     *DtsSettings `json:"dts_settings,omitempty"`
 	EmonJson        *EmonJson    `json:"emon_json,omitempty"`
+	// Revisions keep modified index (v2) or mod revision (v3) of the fetched keys, so Push can detect concurrent writes
+	Revisions map[string]uint64 `json:"-"`
 }
 
 // DTS settings structure
@@ -32,13 +64,19 @@ type DtsSettings struct {
 	Updated string               `json:"updated"`
 }
 
+// Instance contain dts settings of a single application instance. Optional tracking settings are set up centrally:
+// max_file_size is the size in bytes above which files are tracked only by hash (1 MiB by default),
+// include and exclude are globs matched against the relative path or the base name of a file,
+// root paths starting with .git are skipped in addition to exclude
 type Instance struct {
-	AppDir   string `json:"app_dir"`
-	AppName  string `json:"app_name"`
-	Enabled  bool   `json:"enabled"`
-	GitDir   string `json:"git_dir"`
-	WorkTree string `json:"work_tree"`
-	//LockFile string `json:"lock_file"`
+	AppDir      string   `json:"app_dir"`
+	AppName     string   `json:"app_name"`
+	Enabled     bool     `json:"enabled"`
+	GitDir      string   `json:"git_dir"`
+	WorkTree    string   `json:"work_tree"`
+	MaxFileSize int64    `json:"max_file_size,omitempty"`
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
 }
 
 // emon_json structure for DTS
@@ -0,0 +1,78 @@
+package etcd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"go.etcd.io/etcd/client"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrInvalidCa = errors.New("no certificates found in the CA file")
+
+	// tlsConfig is nil when registry is accessed over plain http
+	tlsConfig *tls.Config
+	// transport is shared by reads and writes of v2 api
+	transport client.CancelableTransport = client.DefaultTransport
+)
+
+// newTLSConfig load CA and client certificate given by the config, nil is returned if neither of them is set
+func newTLSConfig(c *Config) (*tls.Config, error) {
+	if len(c.CaFile) == 0 && len(c.CertFile) == 0 {
+		return nil, nil
+	}
+
+	t := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(c.CaFile) > 0 {
+		b, err := ioutil.ReadFile(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		t.RootCAs = x509.NewCertPool()
+		if !t.RootCAs.AppendCertsFromPEM(b) {
+			return nil, ErrInvalidCa
+		}
+	}
+
+	if len(c.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Certificates = []tls.Certificate{cert}
+	}
+
+	return t, nil
+}
+
+// newTransport return transport with the same settings as client.DefaultTransport and the given tls config
+func newTransport(t *tls.Config) client.CancelableTransport {
+	if t == nil {
+		return client.DefaultTransport
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     t,
+	}
+}
+
+// Scheme return url scheme of the registry host depending on whether tls is used
+func Scheme() string {
+	if tlsConfig != nil {
+		return "https"
+	}
+
+	return "http"
+}
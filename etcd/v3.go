@@ -0,0 +1,108 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"go.etcd.io/etcd/clientv3"
+	"strings"
+)
+
+var v3Client *clientv3.Client
+
+// setV3Api connect to the registry host by v3 api, connection is reused by subsequent calls
+func setV3Api(url string) (err error) {
+	if v3Client != nil {
+		return
+	}
+
+	v3Client, err = clientv3.New(clientv3.Config{
+		Endpoints:   endpoints(url),
+		DialTimeout: timeout(),
+		TLS:         tlsConfig,
+		Username:    settings.Username,
+		Password:    settings.Password,
+	})
+
+	return
+}
+
+// fetchV3 read keys under the prefix and arrange them into the same tree of nodes v2 api returns:
+// a node per app with a node per key of the app
+func (config *Etcd) fetchV3(url, prefix string) (err error) {
+	if err = setV3Api(url); err != nil {
+		return
+	}
+
+	var resp *clientv3.GetResponse
+	err = retry(func() (err error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout())
+		defer cancel()
+
+		resp, err = v3Client.Get(ctx, prefix+"/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	config.Action = "get"
+	config.Node = Node{Key: prefix}
+	apps := make(map[string]int)
+	for _, kv := range resp.Kvs {
+		// example: parts = ["5118.3049088120", "dts_settings"]
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix+"/"), "/", 2)
+		i, ok := apps[parts[0]]
+		if !ok {
+			i = len(config.Node.Nodes)
+			apps[parts[0]] = i
+			config.Node.Nodes = append(config.Node.Nodes, Node{Key: prefix + "/" + parts[0]})
+		}
+
+		if len(parts) == 2 {
+			config.Node.Nodes[i].Nodes = append(config.Node.Nodes[i].Nodes, Node{
+				Key:           string(kv.Key),
+				Value:         string(kv.Value),
+				ModifiedIndex: uint64(kv.ModRevision),
+			})
+		}
+	}
+
+	return
+}
+
+// putV3 write all keys in a single transaction, so the app is never left partially updated.
+// Transaction fails if any of json keys was changed since the app was fetched
+func (app *App) putV3(uri string, keys, values []string) (updatedKeys []string, err error) {
+	var cmps []clientv3.Cmp
+	ops := make([]clientv3.Op, len(keys))
+	for i := 0; i < len(keys); i++ {
+		ops[i] = clientv3.OpPut(keys[i], values[i])
+		if name := strings.TrimPrefix(keys[i], uri); casKeys[name] {
+			// mod revision of a missing key is 0
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(keys[i]), "=", int64(app.Revisions[name])))
+		}
+	}
+
+	var resp *clientv3.TxnResponse
+	err = retry(func() (err error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout())
+		defer cancel()
+
+		resp, err = v3Client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	if !resp.Succeeded {
+		return nil, ErrConflict
+	}
+
+	for i := 0; i < len(keys); i++ {
+		app.Revisions[strings.TrimPrefix(keys[i], uri)] = uint64(resp.Header.Revision)
+		updatedKeys = append(updatedKeys, fmt.Sprintf("key put: %s=%s\n", keys[i], values[i]))
+	}
+
+	return
+}
@@ -24,6 +24,12 @@ func main() {
 
 	st.PrepareEnv()
 
+	if st.Args.Action == "serve" {
+		// server fetches registry on each check and keeps running when it is unreachable
+		st.Serve()
+		return
+	}
+
 	st.Fetch()
 
 	switch st.Args.Action {
@@ -31,10 +37,32 @@ func main() {
 		st.PlainInit()
 		st.LogJson()
 	case "status":
-		st.Status()
-		st.Telegraf()
+		if st.Args.All {
+			st.StatusAll()
+			st.TelegrafAll()
+		} else {
+			st.Status()
+			st.Telegraf()
+		}
 		st.LogJson()
 	case "deploy":
 		st.Deploy()
+	case "remove":
+		st.Remove()
+		st.LogJson()
+	case "diff":
+		st.Diff()
+		st.LogJson()
+	case "accept":
+		st.Accept()
+		st.LogJson()
+	case "history":
+		st.History()
+		st.LogJson()
+	case "restore":
+		st.Restore()
+		st.LogJson()
+	case "watch":
+		st.Watch()
 	}
 }
@@ -0,0 +1,126 @@
+package task
+
+import (
+	"../dts"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultWorkers = 4
+
+// InstanceStatus contain result of the status check of a single instance made by status --all
+type InstanceStatus struct {
+	Instance   string       `json:"instance"`
+	AppName    string       `json:"app_name,omitempty"`
+	Files      *Files       `json:"files,omitempty"`
+	MFiles     *dts.MFiles  `json:"m_files,omitempty"`
+	Summary    *dts.Summary `json:"summary,omitempty"`
+	Redeployed bool         `json:"redeployed,omitempty"`
+	Err        string       `json:"err,omitempty"`
+
+	st       *State
+	redeploy bool
+}
+
+// StatusAll check every enabled instance of the host using registry fetched once, instances are checked by a bounded
+// pool of workers. Errors of an instance are kept in its status and don't break checks of the others.
+// Redeploys push to the registry, so they are made one by one after all checks are done
+func (st *State) StatusAll() {
+	instances := make([]string, 0, len(st.DtsApp.DtsSettings.AppList))
+	for k, v := range st.DtsApp.DtsSettings.AppList {
+		// disabled instances are checked only if the target app supports versioning to find out redeploys
+		if v.Enabled || v.WorkTree != v.AppDir {
+			instances = append(instances, k)
+		}
+	}
+	sort.Strings(instances)
+
+	workers := st.Args.Workers
+	if workers < 1 {
+		workers = defaultWorkers
+	}
+
+	st.Statuses = make([]*InstanceStatus, len(instances))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				st.Statuses[i] = st.instanceStatus(instances[i])
+			}
+		}()
+	}
+
+	for i := 0; i < len(instances); i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, is := range st.Statuses {
+		if !is.redeploy {
+			continue
+		}
+
+		if err := is.st.redeploy(); err != nil {
+			is.fail(err)
+			continue
+		}
+
+		is.Redeployed = true
+	}
+}
+
+// instanceStatus check a single instance on its own copy of the state
+func (st *State) instanceStatus(instance string) *InstanceStatus {
+	env := *st.Env
+	env.Instance = instance
+	is := &InstanceStatus{
+		Instance: instance,
+		AppName:  st.DtsApp.DtsSettings.AppList[instance].AppName,
+		st:       &State{registry: st.registry, config: st.config, base: st.base, DtsApp: st.DtsApp, CacheTime: st.CacheTime, Args: st.Args, Env: &env},
+	}
+
+	var err error
+	is.redeploy, err = is.st.status()
+	is.st.Time = time.Now().Format(time.RFC3339)
+	if err != nil {
+		is.fail(err)
+		return is
+	}
+
+	is.Files, is.MFiles, is.Summary = is.st.Files, is.st.MFiles, is.st.Summary
+	return is
+}
+
+func (is *InstanceStatus) fail(err error) {
+	is.Err = err.Error()
+	is.st.Err = is.Err
+	Log.Printf("instance %s: %s\n", is.Instance, err)
+}
+
+// TelegrafAll output status of every checked instance, instances failed to check are skipped
+func (st *State) TelegrafAll() {
+	var output []string
+	for _, is := range st.Statuses {
+		if is.MFiles == nil {
+			continue
+		}
+
+		if st.Args.Format == "prometheus" {
+			output = dts.MergePrometheus(output, is.st.metrics())
+		} else {
+			output = append(output, is.st.metrics()...)
+		}
+	}
+
+	printLines(output)
+}
+
+// prometheus return status of the instance in the prometheus exposition format regardless of the requested format
+func (is *InstanceStatus) prometheus() []string {
+	return append(is.MFiles.Prometheus(is.AppName, is.Instance), is.Summary.Prometheus(is.AppName, is.Instance)...)
+}
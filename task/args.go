@@ -4,14 +4,19 @@ import (
 	"../crc"
 	"../dts"
 	"../etcd"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jessevdk/go-flags"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 const (
@@ -21,6 +26,8 @@ const (
 	etcdTestUrlPref = "vlg-mon-app1"
 	etcdGreenUrl    = "influx.megafon.ru"
 	dtsApplId       = 5118
+	pushRetries     = 5
+	hostLockKey     = "go-dts.lock"
 	logDir          = "/data/logs/go-dts"
 )
 
@@ -34,6 +41,10 @@ var (
 	errAppNameNotMatch     = errors.New("app names not matches")
 	errInstanceDisabled    = errors.New("instance disabled")
 	errInstancesNotMatch   = errors.New("instances do not match")
+	errInstanceBusy        = errors.New("instance is busy, its git dir is used by another go-dts process")
+	errCacheIsEmpty        = errors.New("registry cache is empty")
+	errRegistryIsNotLocked = errors.New("registry must be locked to push dts app")
+	errRedeployFromCache   = errors.New("new version of the instance is deployed, but redeploy is impossible while registry is unreachable")
 	//ErrWorkTreeNotMatch    = errors.New("work tree's do not matches")
 )
 
@@ -45,7 +56,7 @@ func init() {
 func (st *State) ParseArgs(args []string) {
 	st.Args = &Arguments{}
 	parser := flags.NewParser(st.Args, flags.Default)
-	parser.Usage = "--action=[init,status,deploy] [--work-tree [--dts-dir], --instance]"
+	parser.Usage = "--action=[init,status,deploy,remove,diff,accept,history,restore,watch,serve] [--work-tree [--dts-dir], --instance, --all]"
 	if len(args) == 0 {
 		args = os.Args
 	}
@@ -76,12 +87,16 @@ func (st *State) checkArgs(parser *flags.Parser) (err error) {
 		if len(st.Args.WorkTree) == 0 {
 			err = &flags.Error{Type: flags.ErrCommandRequired, Message: "work-tree required for init action"}
 		}
-	case "status":
-		if len(st.Args.Instance) == 0 {
-			err = &flags.Error{Type: flags.ErrCommandRequired, Message: "instance required for status action"}
+	case "status", "remove", "diff", "accept", "history", "restore":
+		if len(st.Args.Instance) == 0 && !(st.Args.All && st.Args.Action == "status") {
+			err = &flags.Error{Type: flags.ErrCommandRequired, Message: fmt.Sprintf("instance required for %s action", st.Args.Action)}
 		}
 	}
 
+	if st.Args.All && (st.Args.Action != "status" || len(st.Args.Instance) > 0) {
+		err = &flags.Error{Type: flags.ErrUnknown, Message: "all can be used only by status action without instance"}
+	}
+
 	return
 }
 
@@ -95,7 +110,8 @@ func (st *State) PrepareEnv() {
 		st.checkError(err)
 	}
 
-	if st.Args.Action == "status" {
+	switch st.Args.Action {
+	case "status", "remove", "diff", "accept", "history", "restore":
 		env.Instance = st.Args.Instance
 	}
 
@@ -107,6 +123,16 @@ func (st *State) PrepareEnv() {
 	env.Hostname, err = getShortHostName()
 	st.checkError(err)
 
+	etcdConfigPath := joinPaths("config", "etcd.yml")
+	etcdConfig, err := getEtcdConfig(etcdConfigPath)
+	if os.IsNotExist(err) {
+		Log.Printf("can't read %s: %s, etcd v2 api is used\n", etcdConfigPath, err)
+		etcdConfig, err = &etcd.Config{}, nil
+	}
+	st.checkError(err)
+	st.checkError(etcd.Configure(etcdConfig))
+	etcd.Logger = Log
+
 	env.EtcdUrl = getEtcdUrl(env.Hostname)
 
 	st.Env = env
@@ -122,35 +148,52 @@ func (st *State) PrepareEnv() {
 			Log.Printf("modified env: %+v\n", *st.Env)
 		}
 	}
+
+	// etcd url may be replaced by the test env
+	st.registry, err = etcd.NewRegistry(st.Env.EtcdUrl)
+	st.checkError(err)
 }
 
 // Fetch data from registry host
 func (st *State) Fetch() {
-	st.config = &etcd.Etcd{}
+	st.checkError(st.fetch())
+}
 
-	city := strings.Split(st.Env.Hostname, "-")[0]
-	// Remove below condition after tests
-	if st.Args.Test {
-		city = "test"
+// fetch do the same as Fetch but return error instead of panicking
+func (st *State) fetch() error {
+	config, err := st.registry.Apps(st.hostPrefix() + "/apps")
+	if err == nil {
+		st.CacheTime = ""
+		if err = st.saveCache(config); err != nil {
+			Log.Println("can't save registry cache:", err)
+		}
+	} else if config, err = st.fetchCache(err); err != nil {
+		return err
 	}
 
-	url := fmt.Sprintf("%s/v2/keys/ps/hosts/%s/%s/apps?recursive=true", st.Env.EtcdUrl, city, st.Env.Hostname)
-
-	err := st.config.FetchConfig(url)
-	st.checkError(err)
-
-	st.DtsApp = &etcd.App{}
-	ok, err := st.config.FetchAppByInstance(st.Env.DtsInstance, st.DtsApp)
-	st.checkError(err)
+	dtsApp := &etcd.App{}
+	ok, err := config.FetchAppByInstance(st.Env.DtsInstance, dtsApp)
+	if err != nil {
+		return err
+	}
 
 	if !ok {
-		if st.Args.Action == "status" {
-			st.checkError(errExtractingDtsApp)
+		switch st.Args.Action {
+		case "status", "remove", "diff", "accept", "history", "restore", "watch", "serve":
+			return errExtractingDtsApp
 		}
 
-		st.DtsApp.DtsSettings = &etcd.DtsSettings{}
-		st.DtsApp.EmonJson = &etcd.EmonJson{}
+		dtsApp.DtsSettings = &etcd.DtsSettings{}
+		dtsApp.EmonJson = &etcd.EmonJson{}
+	}
+
+	base, err := dtsApp.Copy()
+	if err != nil {
+		return err
 	}
+
+	st.config, st.DtsApp, st.base = config, dtsApp, base
+	return nil
 }
 
 func (st *State) Deploy() {
@@ -162,11 +205,15 @@ func (st *State) Deploy() {
 		Log.Println("parsed list of excluded apps from the config:", configPath)
 	}
 
+	st.checkError(st.lock())
+	defer st.unlock()
+
+	// registry is read again under the lock, so instances deployed concurrently are seen
+	st.checkError(st.fetch())
+
 	apps := st.config.CollectApps(ea)
 
 	Log.Println("deploy apps:", apps)
-	err = etcd.SetEtcdApi(st.Env.EtcdUrl)
-	st.checkError(err)
 
 	for i := 0; i < len(apps); i++ {
 		st.Env.Instance = apps[i][1]
@@ -196,24 +243,24 @@ func (st *State) Deploy() {
 
 		st.setDtsApp()
 
-		st.checkError(st.gitInit())
+		il := st.mustLockInstance()
+		err = st.gitInit("deploy")
+		il.unlock()
+		st.checkError(err)
 
 		st.checkError(st.logJson())
 	}
 
-	city := strings.Split(st.Env.Hostname, "-")[0]
-	if st.Args.Test {
-		city = "test"
-	}
-
-	uri := fmt.Sprintf("/ps/hosts/%s/%s/apps/%d.%s/", city, st.Env.Hostname, dtsApplId, st.Env.DtsInstance)
-	updatedKeys, err := st.DtsApp.Push(uri)
-	st.checkError(err)
-
-	Log.Println(updatedKeys)
+	st.checkError(st.push())
 }
 
 func (st *State) PlainInit() {
+	st.checkError(st.lock())
+	defer st.unlock()
+
+	// registry is read again under the lock, so a concurrent init of the same instance is seen
+	st.checkError(st.fetch())
+
 	st.TApp = &etcd.App{}
 	ok, err := st.config.FetchAppByInstance(st.Env.Instance, st.TApp)
 	st.checkError(err)
@@ -230,41 +277,128 @@ func (st *State) PlainInit() {
 		st.checkError(errAppDirNotMatch)
 	}
 
-	st.checkError(st.init())
+	defer st.mustLockInstance().unlock()
+	st.checkError(st.init("init"))
 }
 
-// init function of State can be calling alone in case when steps described in PlainInit function were done somewhere else
-func (st *State) init() (err error) {
+// init function of State can be calling alone in case when steps described in PlainInit function were done somewhere else,
+// trigger is recorded in the baseline commit, caller must hold the registry lock
+func (st *State) init(trigger string) (err error) {
 	st.setDtsApp()
 
-	err = st.gitInit()
+	err = st.gitInit(trigger)
 	if err != nil {
 		return
 	}
 
-	err = etcd.SetEtcdApi(st.Env.EtcdUrl)
+	return st.push()
+}
+
+// push send updated dts app to the registry host, the registry lock must be held and alive
+func (st *State) push() error {
+	if st.hostLock == nil {
+		return errRegistryIsNotLocked
+	}
+
+	if err := st.hostLock.Err(); err != nil {
+		return err
+	}
+
+	uri := fmt.Sprintf("%s/apps/%d.%s/", st.hostPrefix(), dtsApplId, st.Env.DtsInstance)
+	for attempt := 0; ; attempt++ {
+		updatedKeys, err := st.registry.Put(uri, st.DtsApp)
+		if err != etcd.ErrConflict || attempt == pushRetries {
+			if err != nil {
+				return err
+			}
+
+			Log.Println(updatedKeys)
+			return nil
+		}
+
+		Log.Println("dts app was changed concurrently, merging changes...")
+		if err = st.rebase(); err != nil {
+			return err
+		}
+	}
+}
+
+// fetchCache return registry tree from the cache if the action only reads the registry, actions writing to the registry
+// must not act on a stale copy, so they get the fetch error
+func (st *State) fetchCache(fetchErr error) (*etcd.Etcd, error) {
+	switch st.Args.Action {
+	case "status", "watch", "serve":
+	default:
+		return nil, fetchErr
+	}
+
+	c, err := st.loadCache()
 	if err != nil {
-		return
+		Log.Println("can't read registry cache:", err)
+		return nil, fetchErr
 	}
 
+	Log.Printf("registry is unreachable: %s, using cache of %s\n", fetchErr, c.Time)
+	st.CacheTime = c.Time
+	return c.Config, nil
+}
+
+// hostPrefix return registry key of the host, example: /ps/hosts/vlg/vlg-lhrs-app1d
+func (st *State) hostPrefix() string {
 	city := strings.Split(st.Env.Hostname, "-")[0]
 	// Remove below condition after tests
 	if st.Args.Test {
 		city = "test"
 	}
 
-	uri := fmt.Sprintf("/ps/hosts/%s/%s/apps/%d.%s/", city, st.Env.Hostname, dtsApplId, st.Env.DtsInstance)
-	updateKeys, err := st.DtsApp.Push(uri)
+	return fmt.Sprintf("/ps/hosts/%s/%s", city, st.Env.Hostname)
+}
+
+// lock take host-scoped lock in the registry, so deploy, init, redeploy and remove of the host don't interleave
+func (st *State) lock() error {
+	owner := fmt.Sprintf("%s:%d:%s", st.Env.Hostname, os.Getpid(), st.Args.Action)
+	l, err := st.registry.Lock(st.hostPrefix()+"/"+hostLockKey, owner)
 	if err != nil {
-		return
+		return err
 	}
 
-	Log.Println(updateKeys)
-	return
+	st.hostLock = l
+	Log.Println("registry lock taken by", owner)
+	return nil
+}
+
+func (st *State) unlock() {
+	if err := st.hostLock.Unlock(); err != nil {
+		Log.Println("can't release registry lock, it will expire:", err)
+	}
+
+	st.hostLock = nil
+}
+
+// rebase re-read dts app and apply changes made since it was read last time
+func (st *State) rebase() error {
+	latest := &etcd.App{}
+	ok, err := st.registry.App(st.hostPrefix()+"/apps", st.Env.DtsInstance, latest)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		latest.DtsSettings = &etcd.DtsSettings{}
+		latest.EmonJson = &etcd.EmonJson{}
+	}
+
+	base, err := latest.Copy()
+	if err != nil {
+		return err
+	}
+
+	st.DtsApp, st.base = etcd.Rebase(st.DtsApp, st.base, latest), base
+	return nil
 }
 
 // Init external git dir and add accessible files
-func (st *State) gitInit() error {
+func (st *State) gitInit(trigger string) error {
 	gitDir := joinPaths(st.Env.DtsDir, st.Env.Instance)
 	Log.Println("gitInit with env:", st.Env.WorkTree, gitDir)
 	b, err := dts.Init(st.Env.WorkTree, gitDir)
@@ -276,7 +410,7 @@ func (st *State) gitInit() error {
 	Log.Printf("%s\n", output)
 
 	st.Files = &Files{}
-	err = st.Files.walk(st.Env.WorkTree)
+	err = st.Files.walk(st.Env.WorkTree, st.DtsApp.DtsSettings.AppList[st.Env.Instance], st.ignoreFile())
 	if err != nil {
 		return err
 	}
@@ -284,7 +418,8 @@ func (st *State) gitInit() error {
 	log.Println("Symlinks:", st.Files.Symlinks)
 
 	// Add & commit
-	b, err = dts.AddNCommit(st.Env.WorkTree, gitDir, st.Files.Accessible)
+	st.Commit = &dts.Commit{Trigger: trigger, Author: getUserName()}
+	b, err = dts.AddNCommit(st.Env.WorkTree, gitDir, st.Files.Accessible, st.Files.GtSize, st.Files.UnReadable, st.Commit)
 	if err != nil {
 		return err
 	}
@@ -295,80 +430,400 @@ func (st *State) gitInit() error {
 	return err
 }
 
+// Status compare the work tree of the instance with its baseline, when a new version of the target app was deployed
+// the instance is redeployed instead
 func (st *State) Status() {
+	redeploy, err := st.status()
+	st.checkError(err)
+
+	if redeploy {
+		st.checkError(st.redeploy())
+
+		st.checkError(st.logJson())
+		// early exit
+		os.Exit(0)
+	}
+}
+
+// status do the same as Status but return errors instead of panicking, redeploy == true means that a new version
+// of the target app was deployed and the instance has to be redeployed
+func (st *State) status() (redeploy bool, err error) {
 	st.TApp = &etcd.App{}
 	ok, err := st.config.FetchAppByInstance(st.Env.Instance, st.TApp)
-	st.checkError(err)
+	if err != nil {
+		return
+	}
 
 	if !ok {
-		st.checkError(errExtractingTargetApp)
+		return false, errExtractingTargetApp
 	}
 
 	// Check dts config with target application
 	v, ok := st.DtsApp.DtsSettings.AppList[st.Env.Instance]
-	if ok {
-		if !v.Enabled {
-			// check if target app supporting versioning
-			if v.WorkTree != v.AppDir {
-				st.Env.WorkTree, err = resolveCurrentVersion(v.AppDir)
-				st.checkError(err)
-
-				// if work-tree that symlink points to not matches with one obtained from registry host
-				// we consider that a new version of target app was deployed
-				if st.Env.WorkTree != v.WorkTree {
-					Log.Println("new version of target app was deployed, redeploying...")
-
-					st.Env.AppDir = v.AppDir
-
-					// remove instance from app_list
-					delete(st.DtsApp.DtsSettings.AppList, st.Env.Instance)
-					// remove measurement from emon_json
-					st.DtsApp.EmonJson.RemoveMeasurementByInstance(st.Env.Instance)
-					Log.Printf("%s was removed from dts app_list\n", st.Env.Instance)
-
-					// remove git files
-					err = removeGitDir(v.GitDir)
-					st.checkError(err)
-
-					Log.Printf("instance \"%s\" completely removed\n", st.Env.Instance)
-
-					// init new instance
-					st.checkError(st.init())
-
-					st.checkError(st.logJson())
-					// early exit
-					os.Exit(0)
-				}
-			} else {
-				st.checkError(errInstanceDisabled)
+	if !ok {
+		return false, errInstanceIsNotExist
+	}
+
+	if !v.Enabled {
+		// check if target app supporting versioning
+		if v.WorkTree == v.AppDir {
+			return false, errInstanceDisabled
+		}
+
+		st.Env.WorkTree, err = resolveCurrentVersion(v.AppDir)
+		if err != nil {
+			return
+		}
+
+		// if work-tree that symlink points to not matches with one obtained from registry host
+		// we consider that a new version of target app was deployed
+		if st.Env.WorkTree != v.WorkTree {
+			if len(st.CacheTime) > 0 {
+				return false, errRedeployFromCache
 			}
+
+			st.Env.AppDir = v.AppDir
+			return true, nil
+		}
+	}
+
+	st.Env.WorkTree = v.WorkTree
+	st.Env.AppDir = v.AppDir
+
+	//if st.TApp.AppDir != st.Env.WorkTree {
+	//	st.checkError(ErrWorkTreeNotMatch)
+	//}
+
+	if st.TApp.ApplicationName != v.AppName {
+		return false, errAppNameNotMatch
+	}
+
+	il, err := st.lockInstance()
+	if err != nil {
+		return
+	}
+	defer il.unlock()
+
+	st.Files = &Files{}
+	err = st.Files.walk(st.Env.WorkTree, v, st.ignoreFile())
+	if err != nil {
+		return
+	}
+
+	st.MFiles, err = dts.Numstat(st.Env.WorkTree, v.GitDir, st.Files.Accessible, st.Files.GtSize)
+	if err != nil {
+		return
+	}
+
+	st.Files.filter(st.MFiles)
+
+	st.Summary = st.MFiles.Summary(time.Now())
+	st.Summary.UnReadable = len(st.Files.UnReadable)
+	st.Summary.GtSize = len(st.Files.GtSize)
+	st.Summary.Symlinks = len(st.Files.Symlinks)
+	if t, err := time.Parse(time.RFC3339, st.CacheTime); err == nil {
+		st.Summary.Cached = true
+		st.Summary.CacheAge = int64(time.Since(t).Seconds())
+	}
+
+	return
+}
+
+// redeploy reinitialize the instance which work tree was changed by deploying a new version of the target app
+func (st *State) redeploy() error {
+	Log.Println("new version of target app was deployed, redeploying...")
+
+	if err := st.lock(); err != nil {
+		return err
+	}
+	defer st.unlock()
+
+	// registry is read again under the lock, the instance may be redeployed or removed concurrently
+	if err := st.fetch(); err != nil {
+		return err
+	}
+
+	if len(st.CacheTime) > 0 {
+		return errRedeployFromCache
+	}
+
+	v, ok := st.DtsApp.DtsSettings.AppList[st.Env.Instance]
+	if !ok {
+		return errInstanceIsNotExist
+	}
+
+	if v.WorkTree == st.Env.WorkTree {
+		Log.Printf("instance %s is already redeployed\n", st.Env.Instance)
+		return nil
+	}
+
+	il, err := st.lockInstance()
+	if err != nil {
+		return err
+	}
+	defer il.unlock()
+
+	// instance is kept in app_list, so its tracking settings are preserved by init
+	if err = st.removeGit(v); err != nil {
+		return err
+	}
+
+	// init new instance
+	return st.init("redeploy")
+}
+
+// Remove completely deregister instance: removes it from app_list, emon_json and deletes its git dir
+func (st *State) Remove() {
+	st.checkError(st.lock())
+	defer st.unlock()
+
+	st.checkError(st.fetch())
+	v := st.lookupInstance()
+	lockPath := st.lockPath()
+
+	il := st.mustLockInstance()
+	err := st.remove(v)
+	il.unlock()
+	st.checkError(err)
+
+	// lock file is removed after it is closed, since windows doesn't delete open files
+	if err = os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		Log.Println("can't remove lock file:", err)
+	}
+}
+
+// remove deregister the instance and delete its git dir. Git dir is deleted only after the registry is updated,
+// otherwise a failed push would leave registered instance without the baseline
+func (st *State) remove(v *etcd.Instance) error {
+	// remove instance from app_list and its measurement from emon_json
+	st.DtsApp.DtsSettings.RemoveInstance(st.Env.Instance)
+	st.DtsApp.EmonJson.RemoveMeasurementByInstance(st.Env.Instance)
+	if err := st.push(); err != nil {
+		return err
+	}
+	Log.Printf("%s was removed from dts app_list\n", st.Env.Instance)
+
+	if err := removeGitDir(v.GitDir); err != nil {
+		return err
+	}
+
+	Log.Printf("instance \"%s\" completely removed\n", st.Env.Instance)
+	return nil
+}
+
+// removeGit remove measurement of the instance from emon_json and delete its git dir
+func (st *State) removeGit(v *etcd.Instance) error {
+	// remove measurement from emon_json
+	st.DtsApp.EmonJson.RemoveMeasurementByInstance(st.Env.Instance)
+
+	// remove git files
+	return removeGitDir(v.GitDir)
+}
+
+// Diff output content changes of the instance work tree against its baseline as unified text or json
+func (st *State) Diff() {
+	v := st.lookupInstance()
+	defer st.mustLockInstance().unlock()
+	b, err := dts.Diff(v.WorkTree, v.GitDir)
+	st.checkError(err)
+
+	st.FileDiffs = dts.ParseDiff(b)
+	Log.Printf("diff of instance \"%s\": %d files changed\n", st.Env.Instance, len(st.FileDiffs))
+
+	if st.Args.Format == "json" {
+		b, err = json.MarshalIndent(st.FileDiffs, "", "    ")
+		st.checkError(err)
+		b = append(b, '\n')
+	}
+
+	_, err = os.Stdout.Write(b)
+	st.checkError(err)
+}
+
+// Accept create a new baseline of the instance from the current state of its work tree
+func (st *State) Accept() {
+	v := st.lookupInstance()
+	defer st.mustLockInstance().unlock()
+
+	st.Files = &Files{}
+	st.checkError(st.Files.walk(v.WorkTree, v, st.ignoreFile()))
+
+	st.Commit = &dts.Commit{Trigger: "accept", Author: st.Args.Author, Message: st.Args.Message}
+	if len(st.Commit.Author) == 0 {
+		st.Commit.Author = getUserName()
+	}
+
+	b, err := dts.AddNCommit(v.WorkTree, v.GitDir, st.Files.Accessible, st.Files.GtSize, st.Files.UnReadable, st.Commit)
+	st.checkError(err)
+	Log.Println(rmEscape.Replace(string(b)))
+
+	Log.Printf("instance \"%s\" accepted by %s\n", st.Env.Instance, st.Commit.Author)
+}
+
+// History output list of the instance baselines as a table or json
+func (st *State) History() {
+	v := st.lookupInstance()
+	defer st.mustLockInstance().unlock()
+	var err error
+	st.Baselines, err = dts.History(v.WorkTree, v.GitDir)
+	st.checkError(err)
+
+	if st.Args.Format == "json" {
+		var b []byte
+		b, err = json.MarshalIndent(st.Baselines, "", "    ")
+		st.checkError(err)
+		fmt.Println(string(b))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tTIME\tTRIGGER\tAUTHOR\tFILES\tMESSAGE")
+	for _, b := range st.Baselines {
+		trigger := b.Trigger
+		if len(trigger) == 0 {
+			trigger = "-"
 		}
 
-		st.Env.WorkTree = v.WorkTree
-		st.Env.AppDir = v.AppDir
+		message := strings.SplitN(b.Message, "\n", 2)[0]
+		fmt.Fprintf(w, "%.8s\t%s\t%s\t%s\t%d\t%s\n", b.Hash, b.Time, trigger, b.Author, b.Files, message)
+	}
+
+	st.checkError(w.Flush())
+}
 
-		//if st.TApp.AppDir != st.Env.WorkTree {
-		//	st.checkError(ErrWorkTreeNotMatch)
-		//}
+// Restore write baseline content of drifted files back into the instance work tree and remove files added since
+// the baseline, unless files to restore are narrowed by globs. Overwritten and removed files are backed up
+func (st *State) Restore() {
+	v := st.lookupInstance()
+	defer st.mustLockInstance().unlock()
+	hash, files, err := dts.Drifted(v.WorkTree, v.GitDir, st.Args.To)
+	st.checkError(err)
 
-		if st.TApp.ApplicationName != v.AppName {
-			st.checkError(errAppNameNotMatch)
+	st.Restored = &Restoration{Baseline: hash, DryRun: st.Args.DryRun}
+	for i := 0; i < len(files); i++ {
+		if len(st.Args.Files) == 0 || matchGlobs(files[i], st.Args.Files) {
+			st.Restored.Files = append(st.Restored.Files, files[i])
 		}
+	}
 
-		st.MFiles, err = dts.Numstat(st.Env.WorkTree, v.GitDir)
+	if st.Args.Remove {
+		st.Restored.Removed, err = st.addedFiles(v, hash)
 		st.checkError(err)
-	} else {
+	}
+
+	if st.Args.DryRun {
+		for _, file := range st.Restored.Files {
+			Log.Printf("would restore %s from baseline %.8s\n", file, hash)
+			fmt.Println("would restore:", file)
+		}
+
+		for _, file := range st.Restored.Removed {
+			Log.Printf("would remove %s added since baseline %.8s\n", file, hash)
+			fmt.Println("would remove:", file)
+		}
+		return
+	}
+
+	st.Restored.Backup = joinPaths(st.Env.DtsDir, "backup", st.Env.Instance, time.Now().Format("20060102_150405"))
+	for _, file := range st.Restored.Files {
+		b, err := dts.Blob(v.WorkTree, v.GitDir, hash, file)
+		st.checkError(err)
+
+		st.checkError(restoreFile(v.WorkTree, file, st.Restored.Backup, b))
+		Log.Printf("restored %s from baseline %.8s\n", file, hash)
+		fmt.Println("restored:", file)
+	}
+
+	for _, file := range st.Restored.Removed {
+		st.checkError(removeFile(v.WorkTree, file, st.Restored.Backup))
+		Log.Printf("removed %s added since baseline %.8s\n", file, hash)
+		fmt.Println("removed:", file)
+	}
+}
+
+// addedFiles return accessible files of the work tree matching --files globs which are not in the baseline,
+// files kept only by the baseline manifest are part of the baseline too
+func (st *State) addedFiles(v *etcd.Instance, hash string) ([]string, error) {
+	baseline, err := dts.BaselineFiles(v.WorkTree, v.GitDir, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	st.Files = &Files{}
+	if err = st.Files.walk(v.WorkTree, v, st.ignoreFile()); err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]bool, len(baseline))
+	for i := 0; i < len(baseline); i++ {
+		stored[baseline[i]] = true
+	}
+
+	var added []string
+	for _, file := range st.Files.Accessible {
+		if stored[filepath.ToSlash(file)] {
+			continue
+		}
+
+		if len(st.Args.Files) == 0 || matchGlobs(file, st.Args.Files) {
+			added = append(added, file)
+		}
+	}
+
+	sort.Strings(added)
+	return added, nil
+}
+
+// lookupInstance return dts settings of the current instance and set up work tree and app dir from them
+func (st *State) lookupInstance() *etcd.Instance {
+	v, ok := st.DtsApp.DtsSettings.AppList[st.Env.Instance]
+	if !ok {
 		st.checkError(errInstanceIsNotExist)
 	}
+
+	st.Env.WorkTree = v.WorkTree
+	st.Env.AppDir = v.AppDir
+	return v
 }
 
-// Telegraf output status string in telegraf format
+// Telegraf output status per file followed by the instance summary in telegraf format, or in prometheus exposition
+// format if it is requested
 func (st *State) Telegraf() {
-	output := st.MFiles.Telegraf(st.DtsApp.DtsSettings.AppList[st.Env.Instance].AppName)
-	for i := 0; i < len(output); i++ {
-		Log.Println(output[i])
-		fmt.Println(output[i])
+	printLines(st.metrics())
+}
+
+// metrics return status lines of the instance in the requested format
+func (st *State) metrics() []string {
+	appName := st.DtsApp.DtsSettings.AppList[st.Env.Instance].AppName
+	if st.Args.Format == "prometheus" {
+		return append(st.MFiles.Prometheus(appName, st.Env.Instance), st.Summary.Prometheus(appName, st.Env.Instance)...)
 	}
+
+	return append(st.MFiles.Telegraf(st.tags(appName)), st.Summary.Telegraf(st.tags(appName)))
+}
+
+// printLines write lines to stdout and to the log
+func printLines(lines []string) {
+	for i := 0; i < len(lines); i++ {
+		Log.Println(lines[i])
+		fmt.Println(lines[i])
+	}
+}
+
+// tags return influx tags describing the target application on this host
+func (st *State) tags(appName string) map[string]string {
+	tags := map[string]string{
+		"appl_name": appName,
+		"instance":  st.Env.Instance,
+		"host":      st.Env.Hostname,
+	}
+
+	if st.TApp != nil {
+		tags["stand"] = st.TApp.Stand
+		tags["product"] = st.TApp.ProductName
+	}
+
+	return tags
 }
 
 // LogJson marshal current state into json format and write it into default json log
@@ -389,9 +844,9 @@ func getEtcdUrl(sName string) string {
 	testZone := regexp.MustCompile(`^([a-z]{2,4}-?){3}\d+[a-z]$`)
 
 	if testZone.Match([]byte(sName)) {
-		url = fmt.Sprintf("http://%s%s:%s", etcdTestUrlPref, sName[len(sName)-1:], etcdDefaultPort)
+		url = fmt.Sprintf("%s://%s%s:%s", etcd.Scheme(), etcdTestUrlPref, sName[len(sName)-1:], etcdDefaultPort)
 	} else {
-		url = fmt.Sprintf("http://%s:%s", etcdGreenUrl, etcdDefaultPort)
+		url = fmt.Sprintf("%s://%s:%s", etcd.Scheme(), etcdGreenUrl, etcdDefaultPort)
 	}
 
 	return url
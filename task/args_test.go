@@ -0,0 +1,59 @@
+package task
+
+import (
+	"../dts"
+	"../etcd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddedFilesKeepManifestFiles(t *testing.T) {
+	workTree := testWorkTree(t, map[string]string{"a.conf": "a\n", "big.bin": "large file"})
+	v := &etcd.Instance{WorkTree: workTree, GitDir: filepath.Join(t.TempDir(), "42"), MaxFileSize: 5}
+	if _, err := dts.Init(v.WorkTree, v.GitDir); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{Args: &Arguments{}, Env: &Environment{DtsDir: t.TempDir(), Instance: "42"}}
+	st.Files = &Files{}
+	if err := st.Files.walk(v.WorkTree, v, st.ignoreFile()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dts.AddNCommit(v.WorkTree, v.GitDir, st.Files.Accessible, st.Files.GtSize, st.Files.UnReadable, &dts.Commit{Trigger: "init"}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := dts.History(v.WorkTree, v.GitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// big.bin fits the limit now, it is drift of the hashed file rather than an added one
+	v.MaxFileSize = 1 << 20
+	if err = os.MkdirAll(filepath.Join(workTree, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"new.conf", filepath.Join("logs", "new.log")} {
+		if err = ioutil.WriteFile(filepath.Join(workTree, name), []byte("new\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	added, err := st.addedFiles(v, history[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := slashPaths(added); got != "logs/new.log,new.conf" {
+		t.Errorf("addedFiles() = %s, want logs/new.log,new.conf", got)
+	}
+
+	st.Args.Files = []string{"*.conf"}
+	if added, err = st.addedFiles(v, history[0].Hash); err != nil || slashPaths(added) != "new.conf" {
+		t.Errorf("addedFiles() with --files = %q, %v, want new.conf", added, err)
+	}
+}
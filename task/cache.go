@@ -0,0 +1,71 @@
+package task
+
+import (
+	"../etcd"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const registryCacheFile = "registry_cache.json"
+
+// registryCache is the registry tree of the host as it was fetched last time, status falls back to it
+// when the registry is unreachable
+type registryCache struct {
+	Time   string     `json:"time"`
+	Config *etcd.Etcd `json:"config"`
+}
+
+// cacheFile return path of the registry cache kept in the dts dir
+func (st *State) cacheFile() string {
+	return joinPaths(st.Env.DtsDir, registryCacheFile)
+}
+
+// saveCache write fetched registry tree to the cache, the file is replaced at once,
+// so concurrent runs never read a partially written cache
+func (st *State) saveCache(config *etcd.Etcd) error {
+	b, err := json.Marshal(&registryCache{Time: time.Now().Format(time.RFC3339), Config: config})
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(st.Env.DtsDir, registryCacheFile+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+
+	if err == nil {
+		err = mv(f.Name(), st.cacheFile())
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// loadCache read registry tree saved by the latest successful fetch
+func (st *State) loadCache() (*registryCache, error) {
+	b, err := ioutil.ReadFile(st.cacheFile())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &registryCache{}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	if c.Config == nil {
+		return nil, errCacheIsEmpty
+	}
+
+	return c, nil
+}
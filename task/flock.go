@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package task
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile take exclusive flock of the file without waiting, errInstanceBusy is returned if it is already taken
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errInstanceBusy
+	}
+
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,26 @@
+package task
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockOffsetHigh place the locked byte far past the pid written into the file, since windows locks are mandatory
+// and a locked pid couldn't be read by other processes
+const lockOffsetHigh = 0x40000000
+
+// lockFile take exclusive lock of a single byte of the file without waiting, errInstanceBusy is returned
+// if it is already taken
+func lockFile(f *os.File) error {
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{OffsetHigh: lockOffsetHigh})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errInstanceBusy
+	}
+
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{OffsetHigh: lockOffsetHigh})
+}
@@ -0,0 +1,157 @@
+package task
+
+import (
+	"../dts"
+	"../etcd"
+	"bufio"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const dtsIgnoreFile = ".dtsignore"
+
+// excludePrefix skip paths of the work tree root starting with .git along with exclude globs,
+// e.g. .git dir and .gitignore of the root, while nested .gitignore and .gitkeep files are tracked
+const excludePrefix = ".git"
+
+// rules decide which files of the work tree are tracked: include and exclude globs from the instance settings
+// and gitignore patterns from .dtsignore files
+type rules struct {
+	include []string
+	exclude []string
+	ignore  []gitignore.Pattern
+}
+
+// newRules create rules from the instance settings, patterns of the instance ignore file kept in the dts dir
+// have the lowest priority, like .git/info/exclude does
+func newRules(v *etcd.Instance, ignoreFile string) (r *rules, err error) {
+	r = &rules{}
+	if v != nil {
+		r.include, r.exclude = v.Include, v.Exclude
+	}
+
+	r.ignore, err = readPatterns(ignoreFile, nil)
+	return
+}
+
+// skip report whether file or directory at the relative path is not tracked
+func (r *rules) skip(relPath string, isDir bool) bool {
+	if strings.HasPrefix(filepath.ToSlash(relPath), excludePrefix) {
+		return true
+	}
+
+	if matchGlobs(relPath, r.exclude) {
+		return true
+	}
+
+	if len(r.ignore) > 0 && gitignore.NewMatcher(r.ignore).Match(splitPath(relPath), isDir) {
+		return true
+	}
+
+	return !isDir && len(r.include) > 0 && !matchGlobs(relPath, r.include)
+}
+
+// readIgnoreFile append patterns of .dtsignore located in the given directory, relPath is the directory path
+// relative to the work tree
+func (r *rules) readIgnoreFile(dir, relPath string) error {
+	var domain []string
+	if relPath != "." {
+		domain = splitPath(relPath)
+	}
+
+	ps, err := readPatterns(filepath.Join(dir, dtsIgnoreFile), domain)
+	if err != nil {
+		return err
+	}
+
+	// patterns of deeper files are appended last, so they have higher priority
+	r.ignore = append(r.ignore, ps...)
+	return nil
+}
+
+// filter drop changes of files which are not tracked anymore, e.g. they were committed in baseline but now ignored.
+// Rename of an ignored file to a tracked one is reported as added file and the opposite one as deleted
+func (f *Files) filter(mf *dts.MFiles) {
+	for name := range mf.Changes {
+		if f.rules.skip(name, false) {
+			delete(mf.Changes, name)
+		}
+	}
+
+	for from, to := range mf.Renamed {
+		fromSkipped, toSkipped := f.rules.skip(from, false), f.rules.skip(to, false)
+		if !fromSkipped && !toSkipped {
+			continue
+		}
+
+		delete(mf.Renamed, from)
+		if !toSkipped {
+			mf.Added = append(mf.Added, to)
+		} else if !fromSkipped {
+			mf.Deleted = append(mf.Deleted, from)
+		}
+	}
+
+	for name := range mf.Metadata {
+		if f.rules.skip(name, false) {
+			delete(mf.Metadata, name)
+		}
+	}
+
+	mf.Binaries = f.tracked(mf.Binaries)
+	mf.Added = f.tracked(mf.Added)
+	mf.Deleted = f.tracked(mf.Deleted)
+	sort.Strings(mf.Added)
+	sort.Strings(mf.Deleted)
+}
+
+// tracked return names which are not skipped by the rules
+func (f *Files) tracked(names []string) (s []string) {
+	for i := 0; i < len(names); i++ {
+		if !f.rules.skip(names[i], false) {
+			s = append(s, names[i])
+		}
+	}
+
+	return
+}
+
+// ignoreFile return path of the instance ignore file kept in the dts dir
+func (st *State) ignoreFile() string {
+	return st.instanceIgnoreFile(st.Env.Instance)
+}
+
+// instanceIgnoreFile return path of the ignore file of the given instance, example: <DtsDir>/3049088120.dtsignore
+func (st *State) instanceIgnoreFile(instance string) string {
+	return joinPaths(st.Env.DtsDir, instance+dtsIgnoreFile)
+}
+
+// readPatterns read gitignore patterns from the file, missing file means no patterns
+func readPatterns(path string, domain []string) (ps []gitignore.Pattern, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := strings.TrimRight(scanner.Text(), "\r")
+		if !strings.HasPrefix(s, "#") && len(strings.TrimSpace(s)) > 0 {
+			ps = append(ps, gitignore.ParsePattern(s, domain))
+		}
+	}
+
+	return ps, scanner.Err()
+}
+
+func splitPath(relPath string) []string {
+	return strings.Split(filepath.ToSlash(relPath), "/")
+}
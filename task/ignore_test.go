@@ -0,0 +1,158 @@
+package task
+
+import (
+	"../dts"
+	"../etcd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testWorkTree create files of the work tree, missing dirs are created
+func testWorkTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	workTree := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(workTree, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return workTree
+}
+
+func slashPaths(paths []string) string {
+	s := make([]string, len(paths))
+	for i := 0; i < len(paths); i++ {
+		s[i] = filepath.ToSlash(paths[i])
+	}
+
+	return strings.Join(s, ",")
+}
+
+func TestWalkIgnoreRules(t *testing.T) {
+	workTree := testWorkTree(t, map[string]string{
+		".git/config":         "",
+		".gitignore":          "",
+		".dtsignore":          "cache/\n# comment\n\n",
+		"app.conf":            "",
+		"app.log":             "",
+		"cache/data.conf":     "",
+		"local.conf":          "",
+		"logs/.dtsignore":     "!keep.log\n",
+		"logs/debug.log":      "",
+		"logs/keep.log":       "",
+		"sub/.dtsignore":      "/local.conf\n",
+		"sub/.gitignore":      "",
+		"sub/local.conf":      "",
+		"sub/deep/local.conf": "",
+	})
+
+	ignoreFile := filepath.Join(t.TempDir(), "42"+dtsIgnoreFile)
+	if err := ioutil.WriteFile(ignoreFile, []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Files{}
+	if err := f.walk(workTree, nil, ignoreFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// instance ignore file has the lowest priority, nested patterns are relative to their directory
+	want := ".dtsignore,app.conf,local.conf,logs/.dtsignore,logs/keep.log,sub/.dtsignore,sub/.gitignore,sub/deep/local.conf"
+	if got := slashPaths(f.Accessible); got != want {
+		t.Errorf("accessible = %s, want %s", got, want)
+	}
+}
+
+func TestRulesExcludePrefix(t *testing.T) {
+	tests := []struct {
+		v    *etcd.Instance
+		path string
+		skip bool
+	}{
+		{v: nil, path: ".git", skip: true},
+		{v: nil, path: ".gitignore", skip: true},
+		{v: nil, path: filepath.Join("sub", ".gitignore"), skip: false},
+		{v: nil, path: filepath.Join("sub", ".git"), skip: false},
+		{v: &etcd.Instance{Exclude: []string{"*.tmp"}}, path: ".git", skip: true},
+		{v: &etcd.Instance{Exclude: []string{"*.tmp"}}, path: ".gitignore", skip: true},
+		{v: &etcd.Instance{Exclude: []string{"*.tmp"}}, path: filepath.Join("sub", ".gitignore"), skip: false},
+		{v: &etcd.Instance{Exclude: []string{"*.tmp"}}, path: filepath.Join("sub", "a.tmp"), skip: true},
+		{v: &etcd.Instance{Include: []string{"*.conf"}}, path: "app.log", skip: true},
+		{v: &etcd.Instance{Include: []string{"*.conf"}}, path: "app.conf", skip: false},
+	}
+
+	for _, tt := range tests {
+		r, err := newRules(tt.v, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := r.skip(tt.path, false); got != tt.skip {
+			t.Errorf("skip(%q) with %+v = %v, want %v", tt.path, tt.v, got, tt.skip)
+		}
+	}
+}
+
+func TestFilesFilter(t *testing.T) {
+	r, err := newRules(&etcd.Instance{Exclude: []string{"*.log"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Files{rules: r}
+	mf := &dts.MFiles{
+		Changes:  map[string]*dts.Change{"a.conf": {Insertions: 1}, "a.log": {Deletions: 1}},
+		Binaries: []string{"b.bin", "b.log"},
+		Added:    []string{"c.conf", "c.log"},
+		Deleted:  []string{"z.conf", "d.log"},
+		Renamed: map[string]string{
+			"kept.conf": "moved.conf",
+			"old.log":   "tracked.conf",
+			"gone.conf": "ignored.log",
+			"x.log":     "y.log",
+		},
+		Metadata: map[string]*dts.MetaChange{"m.conf": {}, "m.log": {}},
+	}
+
+	f.filter(mf)
+	if _, ok := mf.Changes["a.log"]; ok || len(mf.Changes) != 1 {
+		t.Errorf("changes = %v, want a.conf only", mf.Changes)
+	}
+
+	if slashPaths(mf.Binaries) != "b.bin" {
+		t.Errorf("binaries = %q, want b.bin", mf.Binaries)
+	}
+
+	// rename from an ignored file is an addition, rename into an ignored one is a deletion
+	if got := slashPaths(mf.Added); got != "c.conf,tracked.conf" {
+		t.Errorf("added = %s, want c.conf,tracked.conf", got)
+	}
+
+	if got := slashPaths(mf.Deleted); got != "gone.conf,z.conf" {
+		t.Errorf("deleted = %s, want gone.conf,z.conf", got)
+	}
+
+	if len(mf.Renamed) != 1 || mf.Renamed["kept.conf"] != "moved.conf" {
+		t.Errorf("renamed = %v, want kept.conf renamed to moved.conf", mf.Renamed)
+	}
+
+	if _, ok := mf.Metadata["m.log"]; ok || len(mf.Metadata) != 1 {
+		t.Errorf("metadata = %v, want m.conf only", mf.Metadata)
+	}
+}
+
+func TestInstanceIgnoreFile(t *testing.T) {
+	st := &State{Env: &Environment{DtsDir: filepath.Join("opt", "dts"), Instance: "42"}}
+	if got, want := st.ignoreFile(), joinPaths(st.Env.DtsDir, "42.dtsignore"); got != want {
+		t.Errorf("ignoreFile() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const lockExt = ".lock"
+
+// instanceLock is an exclusive lock of the instance git dir, the lock is released by the os if go-dts dies.
+// Pid of the holder is written into the lock file and cleared on release
+type instanceLock struct {
+	file *os.File
+}
+
+// lockInstance take lock of the instance git dir without waiting, errInstanceBusy is returned if it is used
+// by another go-dts process
+func (st *State) lockInstance() (*instanceLock, error) {
+	path := st.lockPath()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockFile(f); err != nil {
+		f.Close()
+		if err == errInstanceBusy {
+			Log.Printf("instance %s is locked by pid %s\n", st.Env.Instance, readPid(path))
+		}
+
+		return nil, err
+	}
+
+	// the lock is free but the file isn't cleared, so the previous holder has died without releasing it
+	if pid := readPid(path); len(pid) > 0 {
+		Log.Printf("stale lock of instance %s left by pid %s is taken over\n", st.Env.Instance, pid)
+	}
+
+	l := &instanceLock{file: f}
+	if err = l.writePid(); err != nil {
+		l.unlock()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// lockPath return path of the instance lock file kept in the dts dir, it is never taken from the registry
+// since the file is truncated and removed
+func (st *State) lockPath() string {
+	return joinPaths(st.Env.DtsDir, st.Env.Instance+lockExt)
+}
+
+// mustLockInstance do the same as lockInstance but panic through checkError
+func (st *State) mustLockInstance() *instanceLock {
+	l, err := st.lockInstance()
+	st.checkError(err)
+	return l
+}
+
+func (l *instanceLock) writePid() error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return err
+}
+
+// unlock clear the lock file and release the lock
+func (l *instanceLock) unlock() {
+	if err := l.file.Truncate(0); err != nil {
+		Log.Println("can't clear lock file:", err)
+	}
+
+	if err := unlockFile(l.file); err != nil {
+		Log.Println("can't release lock file:", err)
+	}
+
+	l.file.Close()
+}
+
+// readPid return pid written into the lock file, empty if there is none or the file can't be read
+func readPid(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
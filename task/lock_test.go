@@ -0,0 +1,36 @@
+package task
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLockInstance(t *testing.T) {
+	st := &State{Env: &Environment{DtsDir: t.TempDir(), Instance: "42"}}
+	if got, want := st.lockPath(), filepath.Join(st.Env.DtsDir, "42.lock"); got != want {
+		t.Fatalf("lockPath() = %s, want %s", got, want)
+	}
+
+	l, err := st.lockInstance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pid := readPid(st.lockPath()); len(pid) == 0 {
+		t.Error("pid of the holder is not written")
+	}
+
+	if _, err = st.lockInstance(); err != errInstanceBusy {
+		t.Errorf("lockInstance() of locked instance = %v, want %v", err, errInstanceBusy)
+	}
+
+	l.unlock()
+	if pid := readPid(st.lockPath()); len(pid) != 0 {
+		t.Errorf("pid %s is left after unlock", pid)
+	}
+
+	if l, err = st.lockInstance(); err != nil {
+		t.Fatalf("lockInstance() of released instance = %v", err)
+	}
+	l.unlock()
+}
@@ -1,11 +1,14 @@
 package task
 
 import (
+	"../etcd"
 	"errors"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -13,9 +16,10 @@ import (
 )
 
 const (
-	arch        = runtime.GOOS
-	symlinkName = "current"
-	versionsDir = "versions"
+	arch               = runtime.GOOS
+	symlinkName        = "current"
+	versionsDir        = "versions"
+	defaultMaxFileSize = 1 * 1024 * 1024
 	//excludedAppsConfigName = "excluded_apps.yaml"
 )
 
@@ -23,30 +27,46 @@ var (
 	ErrUnsupportedOS         = errors.New("unsupported os, go-dts can be run on linux or windows")
 	ErrWorkTreeIsAFile       = errors.New("work-tree couldn't be a file")
 	ErrVersionLinkIsNotALink = errors.New("version link is not a symlink")
+	ErrOutsideWorkTree       = errors.New("path resolves outside of the work tree")
 	//ErrOSNotSupportSymlinks = errors.New("os do not support symlinks")
 )
 
-// walk recursively walks through the specified directory, distributing each file according to the Files structure
-func (f *Files) walk(workTree string) (err error) {
+// walk recursively walks through the specified directory, distributing each file according to the Files structure,
+// tracking settings of the instance are applied if it is not nil, files matching .dtsignore patterns are skipped
+func (f *Files) walk(workTree string, v *etcd.Instance, ignoreFile string) (err error) {
+	maxFileSize := int64(defaultMaxFileSize)
+	if v != nil && v.MaxFileSize > 0 {
+		maxFileSize = v.MaxFileSize
+	}
+
+	f.rules, err = newRules(v, ignoreFile)
+	if err != nil {
+		return
+	}
+
 	err = filepath.Walk(workTree, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() || info.Name() == "." {
-			return nil
-		}
-
 		var relPath string
 		relPath, err = filepath.Rel(workTree, path)
 		if err != nil {
 			return err
 		}
 
-		if strings.HasPrefix(relPath, ".git") {
+		if relPath != "." && f.rules.skip(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
+		if info.IsDir() {
+			// patterns of nested .dtsignore are relative to its directory
+			return f.rules.readIgnoreFile(path, relPath)
+		}
+
 		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
 			dst, err := os.Readlink(path)
 			if err != nil {
@@ -56,7 +76,7 @@ func (f *Files) walk(workTree string) (err error) {
 			f.Symlinks = append(f.Symlinks, [2]string{relPath, dst})
 		} else if info.Mode()&(1<<2) == 0 {
 			f.UnReadable = append(f.UnReadable, relPath)
-		} else if info.Size() > 1*1024*1024 {
+		} else if info.Size() > maxFileSize {
 			f.GtSize = append(f.GtSize, relPath)
 		} else {
 			f.Accessible = append(f.Accessible, relPath)
@@ -128,6 +148,21 @@ func getExcludedApps(path string) ([]string, error) {
 	return ea.ExcludedApps, nil
 }
 
+// getEtcdConfig read settings of the registry access
+func getEtcdConfig(path string) (*etcd.Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &etcd.Config{}
+	if err = yaml.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 // Rotate log (json or plain)
 func rotate(src string) (dst string, err error) {
 	ext := filepath.Ext(src)
@@ -229,6 +264,150 @@ func getShortHostName() (sName string, err error) {
 	return
 }
 
+// getUserName return name of the user who runs go-dts, the user who invoked sudo takes precedence
+func getUserName() string {
+	if name := os.Getenv("SUDO_USER"); len(name) > 0 {
+		return name
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+
+	return u.Username
+}
+
+// matchGlobs report whether relative path or its base name matches any of the given glob patterns
+func matchGlobs(relPath string, globs []string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for i := 0; i < len(globs); i++ {
+		if ok, _ := path.Match(globs[i], relPath); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(globs[i], path.Base(relPath)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restoreFile copy file at relative path of the work tree into backup dir and replace it with the given content.
+// Symlink at the path is replaced by the file instead of writing through it
+func restoreFile(workTree, relPath, backupDir string, content []byte) error {
+	dst := joinPaths(workTree, relPath)
+	if err := checkInWorkTree(workTree, dst); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Lstat(dst); err == nil {
+		if err = backupFile(dst, joinPaths(backupDir, relPath), fi); err != nil {
+			return err
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if err = os.Remove(dst); err != nil {
+				return err
+			}
+		} else {
+			mode = fi.Mode().Perm()
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dst, content, mode)
+}
+
+// removeFile copy file at relative path of the work tree into backup dir and remove it
+func removeFile(workTree, relPath, backupDir string) error {
+	path := joinPaths(workTree, relPath)
+	if err := checkInWorkTree(workTree, path); err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if err = backupFile(path, joinPaths(backupDir, relPath), fi); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// backupFile copy the file to dst, symlink is copied as a symlink
+func backupFile(src, dst string, fi os.FileInfo) error {
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return copyFile(src, dst, fi.Mode().Perm())
+	}
+
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return os.Symlink(target, dst)
+}
+
+// checkInWorkTree return ErrOutsideWorkTree if the dir of the path resolves outside of the work tree, e.g. one of
+// the dirs was replaced by a symlink. Missing dirs are checked by their nearest existing parent
+func checkInWorkTree(workTree, path string) error {
+	root, err := filepath.EvalSymlinks(workTree)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			dir = resolved
+			break
+		}
+
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return err
+		}
+
+		dir = filepath.Dir(dir)
+	}
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ErrOutsideWorkTree
+	}
+
+	return nil
+}
+
+// copyFile copy content of src file to dst, creating missing parent directories
+func copyFile(src, dst string, mode os.FileMode) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dst, b, mode)
+}
+
 func removeGitDir(gitDir string) error {
 	return os.RemoveAll(gitDir)
 }
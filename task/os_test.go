@@ -0,0 +1,115 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreFileReplaceSymlink(t *testing.T) {
+	workTree, outside, backup := t.TempDir(), t.TempDir(), t.TempDir()
+	target := filepath.Join(outside, "secret")
+	if err := ioutil.WriteFile(target, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(target, filepath.Join(workTree, "app.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreFile(workTree, "app.conf", backup, []byte("baseline")); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadFile(target); string(b) != "outside" {
+		t.Errorf("symlink target is overwritten: %q", b)
+	}
+
+	fi, err := os.Lstat(filepath.Join(workTree, "app.conf"))
+	if err != nil || !fi.Mode().IsRegular() {
+		t.Fatalf("restored file = %v, %v, want regular file", fi, err)
+	}
+
+	if b, _ := ioutil.ReadFile(filepath.Join(workTree, "app.conf")); string(b) != "baseline" {
+		t.Errorf("restored content = %q, want baseline", b)
+	}
+
+	if dst, err := os.Readlink(filepath.Join(backup, "app.conf")); err != nil || dst != target {
+		t.Errorf("backup = %q, %v, want symlink to %s", dst, err, target)
+	}
+}
+
+func TestRestoreFileOutsideWorkTree(t *testing.T) {
+	workTree, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(workTree, "conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := restoreFile(workTree, filepath.Join("conf", "app.conf"), t.TempDir(), []byte("baseline"))
+	if err != ErrOutsideWorkTree {
+		t.Errorf("restoreFile() = %v, want %v", err, ErrOutsideWorkTree)
+	}
+
+	if _, err = os.Stat(filepath.Join(outside, "app.conf")); !os.IsNotExist(err) {
+		t.Errorf("file is written outside of the work tree: %v", err)
+	}
+}
+
+func TestRemoveFile(t *testing.T) {
+	workTree, backup := t.TempDir(), t.TempDir()
+	path := filepath.Join(workTree, "logs", "added.conf")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("added"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeFile(workTree, filepath.Join("logs", "added.conf"), backup); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("added file is not removed: %v", err)
+	}
+
+	if b, _ := ioutil.ReadFile(filepath.Join(backup, "logs", "added.conf")); string(b) != "added" {
+		t.Errorf("backup content = %q, want added", b)
+	}
+}
+
+func TestGetEtcdConfigRetries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "etcd.yml")
+	if err := ioutil.WriteFile(path, []byte("api: v2\nretries: 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := getEtcdConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// explicit zero disables retries, so it must differ from the unset default
+	if c.Retries == nil || *c.Retries != 0 {
+		t.Errorf("retries = %v, want explicit 0", c.Retries)
+	}
+}
+
+func TestGetEtcdConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := getEtcdConfig(filepath.Join(dir, "etcd.yml")); !os.IsNotExist(err) {
+		t.Errorf("getEtcdConfig() of missing file = %v, want not exist error", err)
+	}
+
+	// broken config must not be mistaken for a missing one, it would silently drop tls and endpoints
+	path := filepath.Join(dir, "broken.yml")
+	if err := ioutil.WriteFile(path, []byte("api: v3\nendpoints: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getEtcdConfig(path); err == nil || os.IsNotExist(err) {
+		t.Errorf("getEtcdConfig() of broken file = %v, want parse error", err)
+	}
+}
@@ -0,0 +1,171 @@
+package task
+
+import (
+	"../dts"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server keep results of the latest check of every instance and serve them over http
+type server struct {
+	st *State
+	// check serialize checks, since redeploys change git dirs and push to the registry
+	check sync.Mutex
+
+	mu      sync.RWMutex
+	last    *State
+	checked time.Time
+	err     error
+}
+
+// Serve run http server exposing prometheus metrics of every instance at /metrics, json state of the instance
+// at /status/<instance> and liveness at /healthz. Instances are checked on schedule, /status/<instance>?refresh=true
+// checks the instance on demand. Errors are reported by the response instead of exiting
+func (st *State) Serve() {
+	interval := st.Args.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	s := &server{st: st}
+	go func() {
+		for {
+			s.checkAll()
+			time.Sleep(interval)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.metrics)
+	mux.HandleFunc("/status/", s.status)
+	mux.HandleFunc("/healthz", s.healthz)
+
+	Log.Println("listening on", st.Args.Listen)
+	st.checkError(http.ListenAndServe(st.Args.Listen, mux))
+}
+
+// checkAll fetch registry and check every instance, results of the previous check are kept if registry is unreachable
+func (s *server) checkAll() {
+	s.check.Lock()
+	defer s.check.Unlock()
+
+	env := *s.st.Env
+	round := &State{registry: s.st.registry, Args: s.st.Args, Env: &env}
+	if err := round.fetch(); err != nil {
+		Log.Println("can't fetch registry:", err)
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		return
+	}
+
+	round.StatusAll()
+	if err := round.logJson(); err != nil {
+		Log.Println("can't write json log:", err)
+	}
+
+	s.mu.Lock()
+	s.last, s.checked, s.err = round, time.Now(), nil
+	s.mu.Unlock()
+}
+
+// checkInstance check a single instance on demand against the registry fetched by the latest check
+func (s *server) checkInstance(last *State, instance string) *InstanceStatus {
+	s.check.Lock()
+	defer s.check.Unlock()
+
+	is := last.instanceStatus(instance)
+
+	// redeploy changes dts app shared by the published results
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if is.redeploy {
+		if err := is.st.redeploy(); err != nil {
+			is.fail(err)
+		} else {
+			is.Redeployed = true
+		}
+	}
+
+	for i := 0; i < len(last.Statuses); i++ {
+		if last.Statuses[i].Instance == instance {
+			last.Statuses[i] = is
+		}
+	}
+
+	return is
+}
+
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.last == nil {
+		http.Error(w, "instances are not checked yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	var output []string
+	for _, is := range s.last.Statuses {
+		if is.MFiles != nil {
+			output = dts.MergePrometheus(output, is.prometheus())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(strings.Join(output, "\n") + "\n"))
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	instance := strings.TrimPrefix(r.URL.Path, "/status/")
+
+	s.mu.RLock()
+	last := s.last
+	var is *InstanceStatus
+	if last != nil {
+		for i := 0; i < len(last.Statuses); i++ {
+			if last.Statuses[i].Instance == instance {
+				is = last.Statuses[i]
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if is == nil {
+		http.Error(w, errInstanceIsNotExist.Error(), http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("refresh") == "true" {
+		is = s.checkInstance(last, instance)
+	}
+
+	s.mu.RLock()
+	b, err := json.Marshal(is.st)
+	s.mu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(is.Err) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	_, _ = w.Write(b)
+}
+
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.err != nil {
+		http.Error(w, s.err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -3,15 +3,27 @@ package task
 import (
 	"../dts"
 	"../etcd"
+	"time"
 )
 
 // Command-line arguments
 type Arguments struct {
-	Help     helpOptions `group:"Help Options" json:"-"`
-	Action   string      `short:"a" long:"action" description:"init, status or deploy" choice:"init" choice:"status" choice:"deploy" required:"true" json:"action,omitempty"`
-	WorkTree string      `short:"w" long:"work-tree" description:"path to application" json:"work_tree,omitempty"`
-	Instance string      `short:"i" long:"instance" description:"crc of application path" json:"instance,omitempty"`
-	Test     bool        `short:"t" long:"test" description:"use test args" json:"test,omitempty"`
+	Help     helpOptions   `group:"Help Options" json:"-"`
+	Action   string        `short:"a" long:"action" description:"init, status, deploy, remove, diff, accept, history, restore, watch or serve" choice:"init" choice:"status" choice:"deploy" choice:"remove" choice:"diff" choice:"accept" choice:"history" choice:"restore" choice:"watch" choice:"serve" required:"true" json:"action,omitempty"`
+	WorkTree string        `short:"w" long:"work-tree" description:"path to application" json:"work_tree,omitempty"`
+	Instance string        `short:"i" long:"instance" description:"crc of application path" json:"instance,omitempty"`
+	Format   string        `short:"f" long:"format" description:"output format of status: influx (default) or prometheus, of diff and history: text (default) or json" choice:"text" choice:"json" choice:"influx" choice:"prometheus" json:"format,omitempty"`
+	Message  string        `short:"m" long:"message" description:"reason of accepting changes" json:"message,omitempty"`
+	Author   string        `long:"author" description:"who accepts changes, default is the current user" json:"author,omitempty"`
+	To       string        `long:"to" description:"baseline hash to restore, default is the latest one" json:"to,omitempty"`
+	Files    []string      `long:"files" description:"glob of files to restore, can be repeated" json:"files,omitempty"`
+	Remove   bool          `long:"remove-added" description:"remove files added since the baseline on restore, they are backed up first" json:"remove_added,omitempty"`
+	DryRun   bool          `long:"dry-run" description:"show files to restore without writing them" json:"dry_run,omitempty"`
+	All      bool          `long:"all" description:"check every enabled instance of the host" json:"all,omitempty"`
+	Workers  int           `long:"workers" description:"number of instances checked at once by status --all" default:"4" json:"workers,omitempty"`
+	Interval time.Duration `long:"interval" description:"how often watch re-reads the registry and serve checks instances" default:"5m" json:"interval,omitempty"`
+	Listen   string        `long:"listen" description:"address of the http server started by serve" default:":9118" json:"listen,omitempty"`
+	Test     bool          `short:"t" long:"test" description:"use test args" json:"test,omitempty"`
 }
 
 type helpOptions struct {
@@ -21,15 +33,25 @@ type helpOptions struct {
 
 // Contain current state
 type State struct {
-	config *etcd.Etcd
-	DtsApp *etcd.App    `json:"dts_app"`
-	TApp   *etcd.App    `json:"t_app"`
-	Files  *Files       `json:"files,omitempty"`
-	MFiles *dts.MFiles  `json:"m_files,omitempty"`
-	Args   *Arguments   `json:"args"`
-	Env    *Environment `json:"env"`
-	Time   string       `json:"time"`
-	Err    string       `json:"error,omitempty"`
+	registry  etcd.Registry
+	hostLock  etcd.Locker
+	config    *etcd.Etcd
+	base      *etcd.App         // dts app as it was fetched, merging changes on push conflict needs it
+	DtsApp    *etcd.App         `json:"dts_app"`
+	TApp      *etcd.App         `json:"t_app"`
+	Files     *Files            `json:"files,omitempty"`
+	MFiles    *dts.MFiles       `json:"m_files,omitempty"`
+	Summary   *dts.Summary      `json:"summary,omitempty"`
+	FileDiffs []*dts.FileDiff   `json:"diff,omitempty"`
+	Commit    *dts.Commit       `json:"commit,omitempty"`
+	Baselines []*dts.Baseline   `json:"baselines,omitempty"`
+	Restored  *Restoration      `json:"restore,omitempty"`
+	Statuses  []*InstanceStatus `json:"statuses,omitempty"`
+	CacheTime string            `json:"cache_time,omitempty"` // set if registry was read from the cache
+	Args      *Arguments        `json:"args"`
+	Env       *Environment      `json:"env"`
+	Time      string            `json:"time"`
+	Err       string            `json:"error,omitempty"`
 }
 
 type Files struct {
@@ -37,6 +59,16 @@ type Files struct {
 	UnReadable []string    `json:"unreadable,omitempty"`
 	GtSize     []string    `json:"gt_size,omitempty"`
 	Symlinks   [][2]string `json:"symlinks,omitempty"`
+	rules      *rules
+}
+
+// Restoration contain files written back from the baseline by restore action
+type Restoration struct {
+	Baseline string   `json:"baseline"`
+	DryRun   bool     `json:"dry_run,omitempty"`
+	Backup   string   `json:"backup,omitempty"`
+	Files    []string `json:"files,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
 }
 
 // Environment contain variables generated in runtime before main task execution
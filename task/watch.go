@@ -0,0 +1,209 @@
+package task
+
+import (
+	"../dts"
+	"../etcd"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	eventMeasurement = "data-tracking-system-event"
+	defaultInterval  = 5 * time.Minute
+)
+
+// watched is an instance which work tree is watched for changes
+type watched struct {
+	instance string
+	workTree string
+	rules    *rules
+	tags     map[string]string
+	dirs     []string
+}
+
+// Watch subscribe to filesystem notifications of every enabled instance work tree and output each change as soon as
+// it happens. Registry is re-read periodically to pick up new, removed and redeployed instances
+func (st *State) Watch() {
+	w, err := fsnotify.NewWatcher()
+	st.checkError(err)
+	defer w.Close()
+
+	interval := st.Args.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	instances := make(map[string]*watched)
+	st.refresh(w, instances)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-w.Events:
+			if !ok {
+				return
+			}
+
+			st.event(w, instances, e)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+
+			Log.Println("watch error:", err)
+		case <-ticker.C:
+			if err := st.fetch(); err != nil {
+				Log.Println("can't re-read registry:", err)
+				continue
+			}
+
+			st.refresh(w, instances)
+		case s := <-sig:
+			Log.Println("watch stopped by signal:", s)
+			return
+		}
+	}
+}
+
+// refresh bring watched instances in line with app_list of the registry
+func (st *State) refresh(w *fsnotify.Watcher, instances map[string]*watched) {
+	for k, inst := range instances {
+		if v, ok := st.DtsApp.DtsSettings.AppList[k]; !ok || !v.Enabled || v.WorkTree != inst.workTree {
+			inst.unwatch(w)
+			delete(instances, k)
+			Log.Println("stopped watching instance:", k)
+		}
+	}
+
+	for k, v := range st.DtsApp.DtsSettings.AppList {
+		if _, ok := instances[k]; ok || !v.Enabled {
+			continue
+		}
+
+		inst, err := st.watch(w, k, v)
+		if err != nil {
+			Log.Printf("can't watch instance %s: %s\n", k, err)
+			continue
+		}
+
+		instances[k] = inst
+		Log.Printf("watching instance %s: %d dirs of %s\n", k, len(inst.dirs), inst.workTree)
+	}
+}
+
+// watch subscribe to the instance work tree
+func (st *State) watch(w *fsnotify.Watcher, instance string, v *etcd.Instance) (inst *watched, err error) {
+	inst = &watched{instance: instance, workTree: v.WorkTree}
+	if inst.rules, err = newRules(v, st.instanceIgnoreFile(instance)); err != nil {
+		return
+	}
+
+	tApp := &etcd.App{}
+	if _, err = st.config.FetchAppByInstance(instance, tApp); err != nil {
+		return
+	}
+
+	inst.tags = map[string]string{
+		"appl_name": v.AppName,
+		"instance":  instance,
+		"host":      st.Env.Hostname,
+		"stand":     tApp.Stand,
+		"product":   tApp.ProductName,
+	}
+
+	if err = inst.addDirs(w, inst.workTree); err != nil {
+		inst.unwatch(w)
+	}
+
+	return
+}
+
+// addDirs subscribe to the directory and its subdirectories which are not skipped by the rules,
+// inotify is not recursive so each directory is watched separately
+func (inst *watched) addDirs(w *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(inst.workTree, path)
+		if err != nil {
+			return err
+		}
+
+		if relPath != "." && inst.rules.skip(relPath, true) {
+			return filepath.SkipDir
+		}
+
+		if err = inst.rules.readIgnoreFile(path, relPath); err != nil {
+			return err
+		}
+
+		if err = w.Add(path); err != nil {
+			return err
+		}
+
+		inst.dirs = append(inst.dirs, path)
+		return nil
+	})
+}
+
+func (inst *watched) unwatch(w *fsnotify.Watcher) {
+	for i := 0; i < len(inst.dirs); i++ {
+		// removed directories are already unwatched
+		_ = w.Remove(inst.dirs[i])
+	}
+}
+
+// event output the change of a tracked file, new directories are subscribed to
+func (st *State) event(w *fsnotify.Watcher, instances map[string]*watched, e fsnotify.Event) {
+	var inst *watched
+	for _, v := range instances {
+		if strings.HasPrefix(e.Name, v.workTree+string(filepath.Separator)) && (inst == nil || len(v.workTree) > len(inst.workTree)) {
+			inst = v
+		}
+	}
+
+	if inst == nil {
+		return
+	}
+
+	relPath, err := filepath.Rel(inst.workTree, e.Name)
+	if err != nil {
+		return
+	}
+
+	info, err := os.Lstat(e.Name)
+	isDir := err == nil && info.IsDir()
+	if inst.rules.skip(relPath, isDir) {
+		return
+	}
+
+	if isDir && e.Op&fsnotify.Create == fsnotify.Create {
+		if err = inst.addDirs(w, e.Name); err != nil {
+			Log.Printf("can't watch %s: %s\n", e.Name, err)
+		}
+	}
+
+	p := dts.NewPoint(inst.tags)
+	p.Measurement = eventMeasurement
+	p.Tags["filename"] = filepath.ToSlash(relPath)
+	p.Fields["op"] = strings.ToLower(e.Op.String())
+	p.Fields["dir"] = isDir
+	p.Time = time.Now()
+
+	printLines([]string{p.String()})
+}